@@ -0,0 +1,308 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/imotkin/http-balancer/internal/config"
+)
+
+// Результаты проверки лимитера для записи журнала
+const (
+	LimiterAllowed = "allowed"
+	LimiterDenied  = "denied"
+)
+
+// Формат времени для Apache combined
+const combinedTime = "02/Jan/2006:15:04:05 -0700"
+
+// Запись журнала для одного запроса
+type Entry struct {
+	Time      time.Time
+	RequestID string
+
+	// Адрес клиента с учётом доверенных прокси
+	RemoteAddr string
+
+	Method    string
+	Path      string
+	Proto     string
+	Referer   string
+	UserAgent string
+
+	Status int
+	Bytes  int64
+
+	// Общее время обработки запроса
+	Duration time.Duration
+
+	// Маршрут, группа и адрес выбранного сервера
+	Route    string
+	Pool     string
+	Endpoint string
+
+	// Время от отправки запроса серверу до получения заголовков ответа
+	UpstreamLatency time.Duration
+
+	// Количество повторных запросов к серверам. Прокси пока не повторяет
+	// запросы, поэтому значение всегда 0
+	Retries int
+
+	ClientKey  string
+	ClientName string
+
+	// Результат проверки лимитера (allowed, denied или пусто, если проверки не было)
+	Limiter string
+
+	upstreamStart time.Time
+}
+
+// Отмечает начало запроса к серверу
+func (e *Entry) StartUpstream() {
+	e.upstreamStart = time.Now()
+}
+
+// Отмечает получение ответа (или ошибки) от сервера
+func (e *Entry) EndUpstream() {
+	if !e.upstreamStart.IsZero() && e.UpstreamLatency == 0 {
+		e.UpstreamLatency = time.Since(e.upstreamStart)
+	}
+}
+
+func (e *Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Time              time.Time `json:"time"`
+		RequestID         string    `json:"requestId,omitempty"`
+		RemoteAddr        string    `json:"remoteAddr"`
+		Method            string    `json:"method"`
+		Path              string    `json:"path"`
+		Proto             string    `json:"proto"`
+		Status            int       `json:"status"`
+		Bytes             int64     `json:"bytes"`
+		DurationMs        float64   `json:"durationMs"`
+		Route             string    `json:"route,omitempty"`
+		Pool              string    `json:"pool,omitempty"`
+		Endpoint          string    `json:"endpoint,omitempty"`
+		UpstreamLatencyMs float64   `json:"upstreamLatencyMs,omitempty"`
+		Retries           int       `json:"retries"`
+		ClientKey         string    `json:"clientKey,omitempty"`
+		ClientName        string    `json:"clientName,omitempty"`
+		Limiter           string    `json:"limiter,omitempty"`
+		Referer           string    `json:"referer,omitempty"`
+		UserAgent         string    `json:"userAgent,omitempty"`
+	}{
+		Time:              e.Time,
+		RequestID:         e.RequestID,
+		RemoteAddr:        e.RemoteAddr,
+		Method:            e.Method,
+		Path:              e.Path,
+		Proto:             e.Proto,
+		Status:            e.Status,
+		Bytes:             e.Bytes,
+		DurationMs:        milliseconds(e.Duration),
+		Route:             e.Route,
+		Pool:              e.Pool,
+		Endpoint:          e.Endpoint,
+		UpstreamLatencyMs: milliseconds(e.UpstreamLatency),
+		Retries:           e.Retries,
+		ClientKey:         e.ClientKey,
+		ClientName:        e.ClientName,
+		Limiter:           e.Limiter,
+		Referer:           e.Referer,
+		UserAgent:         e.UserAgent,
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Ключ контекста для записи журнала
+type entryKey struct{}
+
+// Сохраняет запись журнала в контексте, чтобы её могли дополнить обработчики прокси
+func WithEntry(ctx context.Context, entry *Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// Возвращает запись журнала из контекста (nil, если журнал отключён)
+func FromContext(ctx context.Context) *Entry {
+	entry, _ := ctx.Value(entryKey{}).(*Entry)
+	return entry
+}
+
+// Журнал запросов, который записывает каждую запись отдельной строкой
+type Logger struct {
+	mu       sync.Mutex
+	out      io.Writer
+	format   string
+	template *template.Template
+}
+
+func New(cfg config.AccessLog) (*Logger, error) {
+	logger := &Logger{
+		out:    os.Stdout,
+		format: cfg.Format,
+	}
+
+	if cfg.Format == config.AccessLogTemplate {
+		tmpl, err := template.New("access").Parse(cfg.Template)
+		if err != nil {
+			return nil, fmt.Errorf("parse template: %w", err)
+		}
+
+		logger.template = tmpl
+	}
+
+	if cfg.Path != "" {
+		file, err := OpenRotatingFile(
+			cfg.Path,
+			int64(cfg.MaxSize)*1024*1024,
+			cfg.RotateInterval.Duration,
+			int(cfg.MaxBackups),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		logger.out = file
+	}
+
+	return logger, nil
+}
+
+// Проверяет, нужно ли записать запрос при заданной доле записей (0 - записываются все)
+func Sampled(rate float64) bool {
+	return rate == 0 || rate >= 1 || rand.Float64() < rate
+}
+
+// Записывает запись в журнал в выбранном формате
+func (l *Logger) Log(entry *Entry) {
+	var line bytes.Buffer
+
+	switch l.format {
+	case config.AccessLogJSON:
+		json.NewEncoder(&line).Encode(entry)
+	case config.AccessLogCombined:
+		writeCombined(&line, entry)
+	case config.AccessLogTemplate:
+		err := l.template.Execute(&line, entry)
+		if err != nil {
+			log.Println("Failed to format access log entry:", err)
+			return
+		}
+
+		if !bytes.HasSuffix(line.Bytes(), []byte("\n")) {
+			line.WriteByte('\n')
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err := l.out.Write(line.Bytes())
+	if err != nil {
+		log.Println("Failed to write access log entry:", err)
+	}
+}
+
+// Закрывает файл журнала
+func (l *Logger) Close() error {
+	if closer, ok := l.out.(io.Closer); ok && l.out != os.Stdout {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// Формат Apache combined, где в поле пользователя записывается имя клиента,
+// а после User-Agent добавляется количество повторных запросов
+func writeCombined(w io.Writer, e *Entry) {
+	fmt.Fprintf(w, "%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\" %d\n",
+		dash(e.RemoteAddr),
+		dash(strings.ReplaceAll(e.ClientName, " ", "_")),
+		e.Time.Format(combinedTime),
+		e.Method,
+		e.Path,
+		e.Proto,
+		e.Status,
+		combinedBytes(e.Bytes),
+		dash(e.Referer),
+		dash(e.UserAgent),
+		e.Retries,
+	)
+}
+
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+func combinedBytes(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+
+	return fmt.Sprint(n)
+}
+
+// Обёртка для http.ResponseWriter, которая запоминает статус и размер ответа
+type ResponseWriter struct {
+	http.ResponseWriter
+
+	status int
+	bytes  int64
+}
+
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+func (w *ResponseWriter) WriteHeader(code int) {
+	// Информационные ответы (1xx) не являются итоговым статусом
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *ResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+// Возвращает статус ответа (0, если ответ не был отправлен)
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Возвращает количество байт в теле ответа
+func (w *ResponseWriter) Bytes() int64 {
+	return w.bytes
+}
+
+// Возвращает исходный http.ResponseWriter для http.ResponseController
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,149 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Суффикс времени в названии старых файлов журнала
+const backupTime = "20060102T150405.000"
+
+// Файл журнала, который переименовывается и создаётся заново
+// при превышении размера или по истечении интервала
+type RotatingFile struct {
+	path string
+
+	// Максимальный размер файла в байтах (0 - без ограничения)
+	maxSize int64
+
+	// Интервал ротации (0 - без ротации по времени)
+	interval time.Duration
+
+	// Количество сохраняемых старых файлов (0 - все файлы)
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func OpenRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+	}
+
+	err := f.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open access log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat access log: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+
+	return nil
+}
+
+func (f *RotatingFile) Write(b []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.needsRotation(int64(len(b))) {
+		err := f.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(b)
+	f.size += int64(n)
+
+	return n, err
+}
+
+func (f *RotatingFile) needsRotation(next int64) bool {
+	if f.maxSize > 0 && f.size > 0 && f.size+next > f.maxSize {
+		return true
+	}
+
+	return f.interval > 0 && time.Since(f.opened) >= f.interval
+}
+
+// Переименовывает текущий файл, открывает новый и удаляет лишние старые файлы
+func (f *RotatingFile) rotate() error {
+	err := f.file.Close()
+	if err != nil {
+		return fmt.Errorf("close access log: %w", err)
+	}
+
+	backup := f.path + "." + time.Now().Format(backupTime)
+
+	// При нескольких ротациях за одну миллисекунду добавляется номер
+	for i := 1; fileExists(backup); i++ {
+		backup = fmt.Sprintf("%s.%s-%d", f.path, time.Now().Format(backupTime), i)
+	}
+
+	err = os.Rename(f.path, backup)
+	if err != nil {
+		return fmt.Errorf("rename access log: %w", err)
+	}
+
+	err = f.open()
+	if err != nil {
+		return err
+	}
+
+	if f.maxBackups == 0 {
+		return nil
+	}
+
+	backups, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil
+	}
+
+	// Суффиксы с временем упорядочены так же, как и время ротации
+	slices.Sort(backups)
+
+	for len(backups) > f.maxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.file.Close()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
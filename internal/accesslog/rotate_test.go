@@ -0,0 +1,41 @@
+package accesslog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	file, err := OpenRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	// Каждая запись превышает оставшийся размер, поэтому вызывает ротацию
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "fourth\n" {
+		t.Fatalf("unexpected current file: %q, %v", data, err)
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+
+	if len(backups) != 2 {
+		t.Fatalf("unexpected backups: %v", backups)
+	}
+
+	data, _ = os.ReadFile(backups[1])
+
+	if string(data) != "third\n" {
+		t.Fatalf("unexpected last backup: %q", data)
+	}
+}
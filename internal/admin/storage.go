@@ -0,0 +1,162 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/metrics"
+	"github.com/imotkin/http-balancer/internal/tracing"
+)
+
+// Проверка структуры на соответствие интерфейса Storage
+var _ Storage = (*DatabaseStorage)(nil)
+
+type Storage interface {
+	// Создаёт токен и возвращает его вместе с секретом, который больше не хранится
+	Add(ctx context.Context, token Token) (*Token, string, error)
+	Delete(ctx context.Context, id string) error
+	List(ctx context.Context) ([]Token, error)
+
+	// Ищет токен по секрету из заголовка Authorization
+	Authenticate(ctx context.Context, secret string) (*Token, error)
+}
+
+// Хранилище токенов администраторов в той же базе данных, что и клиенты.
+// В базе данных хранится только хеш SHA-256 секрета
+type DatabaseStorage struct {
+	conn *sql.DB
+}
+
+func NewStorage(conn *sql.DB) *DatabaseStorage {
+	return &DatabaseStorage{conn: conn}
+}
+
+func startQuery(ctx context.Context, query string) (context.Context, func()) {
+	start := time.Now()
+
+	ctx, span := tracing.Start(ctx, "storage."+query)
+
+	return ctx, func() {
+		span.End()
+		metrics.ObserveQuery(query, start)
+	}
+}
+
+// Хеш секрета токена. Секрет генерируется случайно и достаточно длинный,
+// поэтому соль для него не требуется
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *DatabaseStorage) Add(ctx context.Context, token Token) (*Token, string, error) {
+	ctx, end := startQuery(ctx, "add_admin_token")
+	defer end()
+
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+
+	token.ID = uuid.NewString()
+	token.CreatedAt = time.Now().UTC().Truncate(time.Second)
+
+	_, err = s.conn.ExecContext(ctx, `
+		INSERT INTO admin_tokens (id, name, token_hash, scopes, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		token.ID, token.Name, hashSecret(encoded), strings.Join(token.Scopes, ","), token.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &token, encoded, nil
+}
+
+func (s *DatabaseStorage) Delete(ctx context.Context, id string) error {
+	ctx, end := startQuery(ctx, "delete_admin_token")
+	defer end()
+
+	res, err := s.conn.ExecContext(ctx, "DELETE FROM admin_tokens WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *DatabaseStorage) List(ctx context.Context) ([]Token, error) {
+	ctx, end := startQuery(ctx, "list_admin_tokens")
+	defer end()
+
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT id, name, scopes, created_at
+		  FROM admin_tokens
+		 ORDER BY created_at, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []Token{}
+
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, *token)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+func (s *DatabaseStorage) Authenticate(ctx context.Context, secret string) (*Token, error) {
+	ctx, end := startQuery(ctx, "authenticate_admin_token")
+	defer end()
+
+	return scanToken(s.conn.QueryRowContext(ctx, `
+		SELECT id, name, scopes, created_at
+		  FROM admin_tokens
+		 WHERE token_hash = $1`, hashSecret(secret)))
+}
+
+func scanToken(row interface{ Scan(...any) error }) (*Token, error) {
+	var (
+		t      Token
+		scopes string
+	)
+
+	err := row.Scan(&t.ID, &t.Name, &scopes, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Scopes = strings.Split(scopes, ",")
+
+	return &t, nil
+}
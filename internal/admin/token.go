@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Права доступа токенов к служебным обработчикам
+const (
+	ScopeClientsRead  = "clients:read"
+	ScopeClientsWrite = "clients:write"
+	ScopeConfigRead   = "config:read"
+	ScopeTokensRead   = "tokens:read"
+	ScopeTokensWrite  = "tokens:write"
+)
+
+// Все права доступа, которые есть у токена из конфигурации и у сертификатов администраторов
+var Scopes = []string{
+	ScopeClientsRead,
+	ScopeClientsWrite,
+	ScopeConfigRead,
+	ScopeTokensRead,
+	ScopeTokensWrite,
+}
+
+// Токен администратора с набором прав доступа
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+}
+
+func (t *Token) Valid() error {
+	if t.Name == "" {
+		return errors.New("empty name")
+	}
+
+	if len(t.Scopes) == 0 {
+		return errors.New("empty scopes")
+	}
+
+	for _, scope := range t.Scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+
+	return nil
+}
+
+// Проверяет, что у токена есть право доступа
+func (t *Token) Allowed(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+type tokenKey struct{}
+
+// Сохраняет токен, с которым выполняется запрос, в контексте
+func WithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// Возвращает токен из контекста запроса или nil
+func FromContext(ctx context.Context) *Token {
+	token, _ := ctx.Value(tokenKey{}).(*Token)
+	return token
+}
@@ -0,0 +1,181 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/imotkin/http-balancer/internal/accesslog"
+	"github.com/imotkin/http-balancer/internal/admin"
+	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/metrics"
+	"github.com/imotkin/http-balancer/internal/server"
+)
+
+// Создаёт служебный сервер с обработчиками для управления клиентами, токенами и метрик
+func (b *Balancer) newAdmin(cfg config.Admin) (*server.Server, *server.Certificates, error) {
+	r := http.NewServeMux()
+
+	// Обработчики для клиентов
+	r.Handle("POST /client", requireScope(admin.ScopeClientsWrite, b.AddClient()))
+	r.Handle("GET /client/{id}", requireScope(admin.ScopeClientsRead, b.GetClient()))
+	r.Handle("GET /clients", requireScope(admin.ScopeClientsRead, b.GetList()))
+	r.Handle("PATCH /client/{id}", requireScope(admin.ScopeClientsWrite, b.UpdateClient()))
+	r.Handle("DELETE /client/{id}", requireScope(admin.ScopeClientsWrite, b.DeleteClient()))
+	r.Handle("POST /client/{id}/keys", requireScope(admin.ScopeClientsWrite, b.AddKey()))
+	r.Handle("GET /client/{id}/keys", requireScope(admin.ScopeClientsRead, b.GetKeys()))
+	r.Handle("PATCH /client/{id}/keys/{keyId}", requireScope(admin.ScopeClientsWrite, b.UpdateKey()))
+	r.Handle("DELETE /client/{id}/keys/{keyId}", requireScope(admin.ScopeClientsWrite, b.DeleteKey()))
+	r.Handle("GET /keys/expiring", requireScope(admin.ScopeClientsRead, b.GetExpiringKeys()))
+	r.Handle("PUT /client/{id}/certificate", requireScope(admin.ScopeClientsWrite, b.BindCertificate()))
+	r.Handle("DELETE /client/{id}/certificate", requireScope(admin.ScopeClientsWrite, b.UnbindCertificate()))
+
+	// Обработчики для токенов администраторов
+	r.Handle("POST /tokens", requireScope(admin.ScopeTokensWrite, b.AddToken()))
+	r.Handle("GET /tokens", requireScope(admin.ScopeTokensRead, b.GetTokens()))
+	r.Handle("DELETE /tokens/{id}", requireScope(admin.ScopeTokensWrite, b.DeleteToken()))
+
+	r.Handle("GET /config", requireScope(admin.ScopeConfigRead, b.GetConfig()))
+	r.Handle("GET /metrics", metrics.Handler())
+
+	var addr string
+
+	switch {
+	case cfg.Socket != "":
+		addr = cfg.Socket
+	case cfg.Authenticated():
+		addr = fmt.Sprintf(":%d", cfg.Port)
+	default:
+		// Без аутентификации служебные обработчики доступны только локально
+		addr = fmt.Sprintf("127.0.0.1:%d", cfg.Port)
+	}
+
+	srv := server.New(addr, b.adminAuth(cfg, r), server.Timeouts{})
+
+	if cfg.Socket != "" {
+		srv.Network = "unix"
+	}
+
+	if !cfg.TLS.Enabled() {
+		return srv, nil, nil
+	}
+
+	tlsConfig, certificates, err := NewServerTLS(cfg.TLS)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Без токена сертификат администратора обязателен
+	if cfg.TLS.ClientCA != "" && cfg.Token == "" {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	srv.TLSConfig = tlsConfig
+
+	return srv, certificates, nil
+}
+
+// Определяет токен администратора для запроса и записывает каждый запрос
+// в журнал аудита вместе с токеном, от имени которого он выполнен
+func (b *Balancer) adminAuth(cfg config.Admin, next http.Handler) http.Handler {
+	// Сравниваются хеши, чтобы время сравнения не зависело от длины токена
+	root := sha256.Sum256([]byte(cfg.Token))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder := accesslog.NewResponseWriter(w)
+
+		token, err := b.adminToken(r, cfg, root)
+		if err != nil {
+			b.logger.Warn("unauthorized admin request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"remote_addr", r.RemoteAddr,
+				"err", err,
+			)
+
+			recorder.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			ResponseError(recorder, "unauthorized", http.StatusUnauthorized)
+		} else {
+			next.ServeHTTP(recorder, r.WithContext(admin.WithToken(r.Context(), token)))
+		}
+
+		status := recorder.Status()
+
+		// Если обработчик не записал ответ, то сервер отправляет статус 200
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		attrs := []any{
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"remote_addr", r.RemoteAddr,
+		}
+
+		if token != nil {
+			attrs = append(attrs, "token_id", token.ID, "token_name", token.Name)
+		}
+
+		b.audit.Info("admin request", attrs...)
+	})
+}
+
+// Возвращает токен администратора по заголовку Authorization или сертификату.
+// Токену из конфигурации и сертификатам администраторов доступны все права.
+// Если способ аутентификации не задан в конфигурации, то служебный сервер
+// доступен только локально и запросы без токена выполняются со всеми правами
+func (b *Balancer) adminToken(r *http.Request, cfg config.Admin, root [sha256.Size]byte) (*admin.Token, error) {
+	authorization := r.Header.Get("Authorization")
+
+	if authorization != "" {
+		secret, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok {
+			return nil, errors.New("unsupported authorization scheme")
+		}
+
+		hash := sha256.Sum256([]byte(secret))
+
+		if cfg.Token != "" && subtle.ConstantTimeCompare(hash[:], root[:]) == 1 {
+			return &admin.Token{ID: "root", Name: "root", Scopes: admin.Scopes}, nil
+		}
+
+		return b.tokens.Authenticate(r.Context(), secret)
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		fingerprint := sha256.Sum256(cert.Raw)
+
+		return &admin.Token{
+			ID:     certificateFingerprint + hex.EncodeToString(fingerprint[:]),
+			Name:   cert.Subject.CommonName,
+			Scopes: admin.Scopes,
+		}, nil
+	}
+
+	if !cfg.Authenticated() {
+		return &admin.Token{ID: "local", Name: "local", Scopes: admin.Scopes}, nil
+	}
+
+	return nil, errors.New("no admin credentials")
+}
+
+// Пропускает запрос, только если у токена администратора есть право доступа
+func requireScope(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := admin.FromContext(r.Context())
+
+		if token == nil || !token.Allowed(scope) {
+			ResponseError(w, "token has no scope "+scope, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -6,23 +6,72 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/imotkin/http-balancer/internal/accesslog"
+	"github.com/imotkin/http-balancer/internal/admin"
 	"github.com/imotkin/http-balancer/internal/client"
 	"github.com/imotkin/http-balancer/internal/config"
 	"github.com/imotkin/http-balancer/internal/limiter"
 	"github.com/imotkin/http-balancer/internal/migrations"
+	"github.com/imotkin/http-balancer/internal/proxyproto"
 	"github.com/imotkin/http-balancer/internal/server"
+	"github.com/imotkin/http-balancer/internal/tracing"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Balancer struct {
 	// Структура-обёртка для http.Server с добавленным graceful shutdown
 	server *server.Server
 
-	// Выбранный алгоритм для работы балансировщика
-	strategy Strategy
+	// Сервер для перенаправления HTTP-запросов на HTTPS
+	redirect *server.Server
+
+	// Служебный сервер для управления клиентами и метрик (nil - отключён)
+	admin *server.Server
+
+	// Сертификаты служебного сервера (nil - служебный сервер без TLS)
+	adminCertificates *server.Certificates
+
+	// Хранилище токенов администраторов с правами доступа
+	tokens admin.Storage
+
+	// Журнал аудита запросов к служебному серверу
+	audit *slog.Logger
+
+	// Сертификаты для HTTPS, которые перезагружаются при изменении файлов
+	certificates *server.Certificates
+
+	// Идентификаторы клиентов по отпечаткам их сертификатов
+	certificateClients sync.Map
+
+	// Слушатели для передачи TCP-соединений
+	tcpListeners []*TCPListener
+
+	// Подсети доверенных прокси для заголовков X-Forwarded-* и Forwarded
+	trustedProxies []netip.Prefix
+
+	// Заголовок с идентификатором запроса
+	requestIDHeader string
+
+	// Журнал запросов (nil - журнал отключён)
+	accessLog *accesslog.Logger
+
+	// Трассировщик для спанов запросов и функция остановки провайдера трассировки
+	tracer          trace.Tracer
+	shutdownTracing func(context.Context) error
+
+	// Группы серверов балансировщика по названиям
+	pools map[string]*Pool
+
+	// Маршруты для переадресации запросов в группы серверов
+	routes []*Route
 
 	// Интервал для проверки (ping) текущего состояния всех серверов балансировщика
 	healthInterval time.Duration
@@ -30,15 +79,15 @@ type Balancer struct {
 	// Структура для работы с ограничением запросов клиентов (Rate Limiting)
 	limiter *limiter.Limiter
 
-	// Мютекс для работы с общими для разных горутин данными
-	mu sync.Mutex
-
 	// Логгер для событий балансировщика
 	logger *slog.Logger
 
 	// Хранилище для работы с клиентами балансировщика
 	clients client.Storage
 
+	// Результат последней проверки ключей, срок действия которых скоро истекает
+	expiringKeys atomic.Pointer[ExpiringKeys]
+
 	// Конфигурация балансирощика
 	config *config.Config
 }
@@ -50,15 +99,34 @@ func New(cfg *config.Config) (*Balancer, error) {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
 
-	endpoints := make([]*Endpoint, 0, len(cfg.Endpoints))
+	pools := make(map[string]*Pool)
 
-	for _, u := range cfg.Endpoints {
-		endpoint, err := NewEndpoint(u, cfg.HealthInterval.Duration, cfg.LogLevel())
+	for _, p := range cfg.UpstreamPools() {
+		pool, err := NewPool(p, cfg.HealthInterval.Duration, cfg.LogLevel())
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("create pool %q: %w", p.Name, err)
 		}
 
-		endpoints = append(endpoints, endpoint)
+		pools[p.Name] = pool
+	}
+
+	routes := make([]*Route, 0, len(cfg.UpstreamRoutes()))
+
+	for _, r := range cfg.UpstreamRoutes() {
+		grpc := r.Type == config.RouteGRPC
+
+		routes = append(routes, &Route{
+			pattern:            r.Pattern(),
+			grpc:               grpc,
+			pool:               pools[r.Pool],
+			timeout:            r.Timeout.Duration,
+			upgradeIdleTimeout: r.Upgrade.IdleTimeout.Duration,
+			upgrades:           limiter.NewConcurrency(r.Upgrade.MaxPerClient),
+			stream:             r.Stream.Enabled || grpc,
+			streamIdleTimeout:  r.Stream.IdleTimeout.Duration,
+
+			accessLogSampleRate: r.AccessLogSampleRate,
+		})
 	}
 
 	addr := fmt.Sprintf(":%d", cfg.Port)
@@ -71,11 +139,13 @@ func New(cfg *config.Config) (*Balancer, error) {
 		output = os.Stdout
 	}
 
-	logger := slog.New(slog.NewJSONHandler(
-		output, &slog.HandlerOptions{
-			Level: cfg.LogLevel(),
-		}),
-	)
+	logger := slog.New(requestIDHandler{
+		Handler: slog.NewJSONHandler(
+			output, &slog.HandlerOptions{
+				Level: cfg.LogLevel(),
+			},
+		),
+	})
 
 	var driver, path string
 
@@ -102,43 +172,100 @@ func New(cfg *config.Config) (*Balancer, error) {
 		return nil, fmt.Errorf("run migrations: %w", err)
 	}
 
-	limiter := limiter.New(*storage)
+	trustedProxies, err := proxyproto.ParseTrusted(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("parse trusted proxies: %w", err)
+	}
 
-	var (
-		strategy         Strategy
-		trackConnections bool
-	)
+	limiter := limiter.New(*storage, cfg.UnknownClients)
 
-	switch cfg.Strategy {
-	case RoundRobinStrategy:
-		strategy = &RoundRobin{endpoints: endpoints}
-	case RandomStrategy:
-		strategy = &Random{endpoints: endpoints}
-	case LeastConnectionsStrategy:
-		strategy = &LeastConnections{endpoints: endpoints}
-		trackConnections = true
+	balancer := &Balancer{
+		limiter:        limiter,
+		logger:         logger,
+		clients:        storage,
+		tokens:         admin.NewStorage(storage.Connection()),
+		config:         cfg,
+		pools:          pools,
+		routes:         routes,
+		trustedProxies: trustedProxies,
 	}
 
-	balancer := &Balancer{
-		limiter:  limiter,
-		logger:   logger,
-		clients:  storage,
-		config:   cfg,
-		strategy: strategy,
+	balancer.requestIDHeader = http.CanonicalHeaderKey(cfg.RequestIDHeader)
+
+	if balancer.requestIDHeader == "" {
+		balancer.requestIDHeader = DefaultRequestIDHeader
+	}
+
+	// Журнал аудита записывается в стандартный вывод независимо от уровня
+	// логирования, в том числе при отключённом логировании
+	balancer.audit = slog.New(slog.NewJSONHandler(os.Stdout, nil)).With("log", "audit")
+
+	if cfg.Admin.Enabled() {
+		balancer.admin, balancer.adminCertificates, err = balancer.newAdmin(cfg.Admin)
+		if err != nil {
+			return nil, fmt.Errorf("configure admin listener: %w", err)
+		}
+	}
+
+	provider, shutdown, err := tracing.NewProvider(context.Background(), cfg.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("configure tracing: %w", err)
+	}
+
+	balancer.tracer = provider.Tracer(tracing.Name)
+	balancer.shutdownTracing = shutdown
+
+	if cfg.AccessLog.Enabled() {
+		balancer.accessLog, err = accesslog.New(cfg.AccessLog)
+		if err != nil {
+			return nil, fmt.Errorf("create access log: %w", err)
+		}
 	}
 
 	r := http.NewServeMux()
 
-	// Обработчики для клиентов
-	r.Handle("POST /client", balancer.AddClient())
-	r.Handle("GET /client/{key}", balancer.GetClient())
-	r.Handle("GET /clients", balancer.GetList())
-	r.Handle("DELETE /client/{key}", balancer.DeleteClient())
+	// Публичный сервер обрабатывает только балансировку запросов,
+	// обработчики для клиентов доступны на служебном сервере
+	for _, route := range routes {
+		r.Handle(route.pattern, balancer.Forward(route))
+	}
+
+	balancer.server = server.New(addr, r, server.Timeouts{
+		Read:       cfg.Server.ReadTimeout.Duration,
+		ReadHeader: cfg.Server.ReadHeaderTimeout.Duration,
+		Write:      cfg.Server.WriteTimeout.Duration,
+		Idle:       cfg.Server.IdleTimeout.Duration,
+	})
+
+	balancer.server.WrapListener, err = NewProxyListener(cfg.Server.ProxyProtocol)
+	if err != nil {
+		return nil, fmt.Errorf("configure PROXY protocol: %w", err)
+	}
 
-	// Обработчик для балансировки запросов
-	r.Handle("/", balancer.Forward(trackConnections))
+	for _, l := range cfg.Listeners {
+		listener, err := NewTCPListener(l, pools, logger)
+		if err != nil {
+			return nil, fmt.Errorf("create listener %d: %w", l.Port, err)
+		}
 
-	balancer.server = server.New(addr, r)
+		balancer.tcpListeners = append(balancer.tcpListeners, listener)
+	}
+
+	if cfg.TLS.Enabled() {
+		tlsConfig, certificates, err := NewServerTLS(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("configure TLS: %w", err)
+		}
+
+		balancer.server.TLSConfig = tlsConfig
+		balancer.certificates = certificates
+
+		if cfg.TLS.RedirectPort != 0 {
+			balancer.redirect = server.NewRedirect(
+				fmt.Sprintf(":%d", cfg.TLS.RedirectPort), cfg.Port,
+			)
+		}
+	}
 
 	return balancer, nil
 }
@@ -148,5 +275,58 @@ func (b *Balancer) Start(ctx context.Context) {
 		ctx, b.config.RefillInterval.Duration,
 	)
 
+	if b.certificates != nil {
+		interval := b.config.TLS.ReloadInterval.Duration
+		if interval == 0 {
+			interval = defaultReloadInterval
+		}
+
+		go b.certificates.Watch(ctx, interval)
+	}
+
+	if b.adminCertificates != nil {
+		interval := b.config.Admin.TLS.ReloadInterval.Duration
+		if interval == 0 {
+			interval = defaultReloadInterval
+		}
+
+		go b.adminCertificates.Watch(ctx, interval)
+	}
+
+	go b.watchKeyUsage(ctx)
+	go b.watchExpiringKeys(ctx)
+
+	go b.watchRevocations(ctx)
+
+	if b.redirect != nil {
+		go b.redirect.Listen(ctx)
+	}
+
+	if b.admin != nil {
+		go b.admin.Listen(ctx)
+	}
+
+	// Слушатели TCP закрываются по тем же сигналам, что и HTTP-сервер
+	listenCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	for _, listener := range b.tcpListeners {
+		go func() {
+			err := listener.Listen(listenCtx)
+			if err != nil {
+				b.logger.Error("start TCP listener", "addr", listener.addr, "err", err)
+			}
+		}()
+	}
+
 	b.server.Listen(ctx)
+
+	if b.accessLog != nil {
+		b.accessLog.Close()
+	}
+
+	err := b.shutdownTracing(context.Background())
+	if err != nil {
+		b.logger.Error("shutdown tracing", "err", err)
+	}
 }
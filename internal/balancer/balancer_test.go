@@ -1,16 +1,43 @@
 package balancer
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/client"
 	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/limiter"
+	"github.com/imotkin/http-balancer/internal/proxyproto"
+	"github.com/imotkin/http-balancer/internal/tracing"
+	"github.com/pressly/goose/v3"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func BenchmarkBalancerTestSingle(b *testing.B) {
@@ -62,7 +89,7 @@ func BenchmarkBalancerTestSingle(b *testing.B) {
 
 	for b.Loop() {
 		resp := httptest.NewRecorder()
-		balancer.Forward(false).ServeHTTP(resp, req)
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
 
 		if resp.Code != http.StatusOK {
 			b.Fatalf("unexpected code: %d", resp.Code)
@@ -121,7 +148,7 @@ func BenchmarkBalancerSingle(b *testing.B) {
 
 	for b.Loop() {
 		resp := httptest.NewRecorder()
-		balancer.Forward(false).ServeHTTP(resp, req)
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
 
 		if resp.Code != http.StatusOK {
 			b.Fatalf("unexpected code: %d", resp.Code)
@@ -183,10 +210,2075 @@ func BenchmarkBalancerMultiple(b *testing.B) {
 
 	for b.Loop() {
 		resp := httptest.NewRecorder()
-		balancer.Forward(false).ServeHTTP(resp, req)
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
 
 		if resp.Code != http.StatusOK {
 			b.Fatalf("unexpected code: %d", resp.Code)
 		}
 	}
 }
+
+// Создаёт балансировщик для тестов и клиента с большой ёмкостью, возвращает его ключ
+func newTestBalancer(t testing.TB, cfg *config.Config) (*Balancer, string) {
+	t.Helper()
+
+	cfg.LoggingLevel = "none"
+	cfg.MigrationsPath = "./../../migrations"
+	cfg.FilePath = t.TempDir() + "/clients.sqlite"
+
+	balancer, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create a balancer: %v", err)
+	}
+
+	body := `{"name":"test-client","capacity":10000000,"rate":100}`
+	req := httptest.NewRequest("POST", "/client", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	balancer.AddClient().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to add client, got status code: %d", rr.Code)
+	}
+
+	var key ResponseKey
+
+	if err := json.NewDecoder(rr.Body).Decode(&key); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	return balancer, key.Key
+}
+
+// Возвращает идентификатор клиента по его ключу
+func testClientID(t testing.TB, balancer *Balancer, key string) string {
+	t.Helper()
+
+	c, _, err := balancer.clients.FindByKey(context.Background(), key)
+	if err != nil {
+		t.Fatalf("failed to find client: %v", err)
+	}
+
+	return c.ID
+}
+
+func TestForwardRouteTimeout(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.Routes = []config.Route{{
+		Path:    "/",
+		Pool:    config.DefaultPool,
+		Timeout: config.Duration{Duration: 50 * time.Millisecond},
+	}}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", key)
+	resp := httptest.NewRecorder()
+
+	balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected code: %d", resp.Code)
+	}
+
+	var message ResponseMessage
+
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if message.Code != http.StatusGatewayTimeout {
+		t.Fatalf("unexpected message code: %d", message.Code)
+	}
+}
+
+func TestInvalidRoutes(t *testing.T) {
+	tests := []struct {
+		name   string
+		routes []string
+		err    string
+	}{
+		{name: "without leading slash", routes: []string{"api"}, err: "must start with /"},
+		{name: "host pattern", routes: []string{"api/"}, err: "must start with /"},
+		{name: "method without slash", routes: []string{"GET api"}, err: "must start with /"},
+		{name: "malformed wildcard", routes: []string{"/api/{id"}, err: "invalid route"},
+		{name: "invalid wildcard name", routes: []string{"/api/{a-b}"}, err: "invalid route"},
+		{name: "overlapping patterns", routes: []string{"/api/{id}", "/api/{name}"}, err: "invalid route"},
+		{name: "conflicting methods", routes: []string{"GET /{id}/x", "/a/{name}"}, err: "invalid route"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+
+			cfg.Endpoints = []string{"http://localhost:8081"}
+
+			for _, path := range tt.routes {
+				cfg.Routes = append(cfg.Routes, config.Route{Path: path, Pool: config.DefaultPool})
+			}
+
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("unexpected error: %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	// Первый сервер неактивен, поэтому выбор начинается не с него
+	endpoints := []*Endpoint{{}, {}, {}}
+
+	endpoints[1].active.Store(true)
+	endpoints[1].connections.Store(2)
+	endpoints[2].active.Store(true)
+
+	strategy := &LeastConnections{endpoints: endpoints}
+
+	if got := strategy.Next(); got != endpoints[2] {
+		t.Fatalf("unexpected endpoint: %p, want %p", got, endpoints[2])
+	}
+
+	endpoints[2].connections.Store(3)
+
+	if got := strategy.Next(); got != endpoints[1] {
+		t.Fatalf("unexpected endpoint: %p, want %p", got, endpoints[1])
+	}
+
+	endpoints[1].active.Store(false)
+	endpoints[2].active.Store(false)
+
+	if got := strategy.Next(); got != nil {
+		t.Fatalf("inactive endpoint is selected: %p", got)
+	}
+}
+
+func TestForwardUpgrade(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+
+		io.Copy(conn, brw)
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.Routes = []config.Route{{
+		Path: "/",
+		Pool: config.DefaultPool,
+		Upgrade: config.Upgrade{
+			IdleTimeout:  config.Duration{Duration: time.Minute},
+			MaxPerClient: 1,
+		},
+	}}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	route := balancer.routes[0]
+
+	server := httptest.NewServer(balancer.Forward(route))
+	defer server.Close()
+
+	dial := func() (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: test\r\nX-API-Key: %s\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n", key)
+
+		reader := bufio.NewReader(conn)
+
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+
+		return conn, reader, resp
+	}
+
+	conn, reader, resp := dial()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected code: %d", resp.StatusCode)
+	}
+
+	fmt.Fprint(conn, "ping\n")
+
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("unexpected echo: %q, %v", line, err)
+	}
+
+	if n := route.pool.endpoints[0].Connections(); n != 1 {
+		t.Fatalf("unexpected connections count: %d", n)
+	}
+
+	second, _, resp := dial()
+	second.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("unexpected code for second connection: %d", resp.StatusCode)
+	}
+
+	conn.Close()
+
+	for range 100 {
+		if route.pool.endpoints[0].Connections() == 0 && route.upgrades.Count(key) == 0 {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("connection is still counted after close")
+}
+
+func TestForwardStream(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		for i := range 5 {
+			fmt.Fprintf(w, "{\"event\":%d}\n", i)
+			http.NewResponseController(w).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.Routes = []config.Route{{
+		Path: "/",
+		Pool: config.DefaultPool,
+		Stream: config.Stream{
+			Enabled:     true,
+			IdleTimeout: config.Duration{Duration: time.Second},
+		},
+	}}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	// Таймаут записи сервера меньше общего времени потока
+	server := httptest.NewUnstartedServer(balancer.Forward(balancer.routes[0]))
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("X-API-Key", key)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+
+	if n := strings.Count(string(body), "\n"); n != 5 {
+		t.Fatalf("unexpected number of events: %d", n)
+	}
+}
+
+func TestForwardH2C(t *testing.T) {
+	endpoint := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	}))
+	endpoint.Config.Protocols = new(http.Protocols)
+	endpoint.Config.Protocols.SetUnencryptedHTTP2(true)
+	endpoint.Start()
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{strings.Replace(endpoint.URL, "http://", "h2c://", 1)}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	server := httptest.NewUnstartedServer(balancer.Forward(balancer.routes[0]))
+	server.Config.Protocols = balancer.server.Protocols
+	server.Start()
+	defer server.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("X-API-Key", key)
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+		t.Fatalf("unexpected protocols: balancer %s, endpoint %s", resp.Proto, body)
+	}
+}
+
+func TestForwardGRPC(t *testing.T) {
+	endpoint := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write([]byte{0, 0, 0, 0, 0})
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	endpoint.Config.Protocols = new(http.Protocols)
+	endpoint.Config.Protocols.SetUnencryptedHTTP2(true)
+	endpoint.Start()
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Pools = []config.Pool{
+		{Name: "grpc", Endpoints: []string{endpoint.URL}, Protocol: config.ProtocolHTTP2},
+		{Name: "down", Endpoints: []string{"h2c://localhost:1"}},
+	}
+	cfg.Routes = []config.Route{
+		{Type: config.RouteGRPC, Path: "test.Echo", Pool: "grpc"},
+		{Type: config.RouteGRPC, Path: "test.Down/Call", Pool: "down"},
+	}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	mux := http.NewServeMux()
+
+	for _, route := range balancer.routes {
+		mux.Handle(route.pattern, balancer.Forward(route))
+	}
+
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.Protocols = balancer.server.Protocols
+	server.Start()
+	defer server.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+
+	call := func(method, key string) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+method, strings.NewReader(""))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("X-API-Key", key)
+
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		return resp
+	}
+
+	resp := call("/test.Echo/Call", key)
+
+	if resp.StatusCode != http.StatusOK || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Fatalf("unexpected response: %d, trailers %v", resp.StatusCode, resp.Trailer)
+	}
+
+	resp = call("/test.Down/Call", key)
+
+	if status := resp.Header.Get("Grpc-Status"); status != "14" {
+		t.Fatalf("unexpected status for unavailable endpoint: %q", status)
+	}
+
+	resp = call("/test.Echo/Call", "")
+
+	if status := resp.Header.Get("Grpc-Status"); status != "16" {
+		t.Fatalf("unexpected status for missing key: %q", status)
+	}
+}
+
+// Создаёт самоподписанный сертификат клиента и возвращает его вместе с ключом в формате PEM
+func newClientCertificate(t testing.TB, name string) ([]byte, []byte, *x509.Certificate) {
+	t.Helper()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	cert, _ := x509.ParseCertificate(der)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		cert
+}
+
+func TestForwardUpstreamMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	certPEM, keyPEM, clientCert := newClientCertificate(t, "balancer")
+
+	os.WriteFile(dir+"/client.crt", certPEM, 0o600)
+	os.WriteFile(dir+"/client.key", keyPEM, 0o600)
+
+	endpoint := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	endpoint.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	endpoint.TLS.ClientCAs.AddCert(clientCert)
+	endpoint.StartTLS()
+	defer endpoint.Close()
+
+	os.WriteFile(dir+"/ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: endpoint.Certificate().Raw}), 0o600)
+
+	hash := sha256.Sum256(endpoint.Certificate().RawSubjectPublicKeyInfo)
+
+	upstream := config.UpstreamTLS{
+		CA:         dir + "/ca.crt",
+		Cert:       dir + "/client.crt",
+		Key:        dir + "/client.key",
+		ServerName: "example.com",
+		Pins:       []string{base64.StdEncoding.EncodeToString(hash[:])},
+	}
+
+	wrongPin := upstream
+	wrongPin.Pins = []string{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}
+
+	cfg := config.Default()
+
+	cfg.Pools = []config.Pool{
+		{Name: "pinned", Endpoints: []string{endpoint.URL}, TLS: upstream},
+		{Name: "wrong", Endpoints: []string{endpoint.URL}, TLS: wrongPin},
+	}
+	cfg.Routes = []config.Route{
+		{Path: "/pinned", Pool: "pinned"},
+		{Path: "/wrong", Pool: "wrong"},
+	}
+
+	balancer, apiKey := newTestBalancer(t, cfg)
+
+	for i, want := range []int{http.StatusOK, http.StatusServiceUnavailable} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", apiKey)
+		resp := httptest.NewRecorder()
+
+		balancer.Forward(balancer.routes[i]).ServeHTTP(resp, req)
+
+		if resp.Code != want {
+			t.Fatalf("unexpected code for %s: %d", balancer.routes[i].pattern, resp.Code)
+		}
+
+		if want == http.StatusOK && resp.Body.String() != "balancer" {
+			t.Fatalf("unexpected client certificate: %q", resp.Body.String())
+		}
+	}
+}
+
+func TestForwardClientCertificate(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	certPEM, keyPEM, cert := newClientCertificate(t, "tenant")
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load client certificate: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(balancer.Forward(balancer.routes[0]))
+	server.TLS = &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	server.TLS.ClientCAs.AddCert(cert)
+	server.StartTLS()
+	defer server.Close()
+
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{pair}
+
+	get := func() int {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if code := get(); code != http.StatusUnauthorized {
+		t.Fatalf("unexpected code for unbound certificate: %d", code)
+	}
+
+	body := `{"certificate":"subject:CN=tenant"}`
+	id := testClientID(t, balancer, key)
+
+	req := httptest.NewRequest("PUT", "/client/"+id+"/certificate", strings.NewReader(body))
+	req.SetPathValue("id", id)
+	rr := httptest.NewRecorder()
+
+	balancer.BindCertificate().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to bind certificate: %d", rr.Code)
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("unexpected code for bound certificate: %d", code)
+	}
+}
+
+func TestTCPListener(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer echo.Close()
+
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	cfg := config.Default()
+
+	cfg.Pools = []config.Pool{
+		{Name: "http", Endpoints: []string{"http://localhost:1"}},
+		{Name: "echo", Endpoints: []string{"tcp://" + echo.Addr().String()}},
+	}
+	cfg.Listeners = []config.Listener{{
+		Type:      config.ListenerTCP,
+		Port:      9000,
+		Pool:      "echo",
+		RateLimit: config.RateLimit{Capacity: 1, Rate: 1},
+	}}
+
+	balancer, _ := newTestBalancer(t, cfg)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go balancer.tcpListeners[0].Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprint(conn, "ping\n")
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("unexpected echo: %q, %v", line, err)
+	}
+
+	// Второе соединение с того же адреса превышает лимит и сразу закрывается
+	second, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer second.Close()
+
+	second.SetReadDeadline(time.Now().Add(time.Second))
+
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected closed connection, got: %v", err)
+	}
+}
+
+func TestTLSPassthroughListener(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, name)
+		}))
+	}
+
+	first, second := backend("first"), backend("second")
+	defer first.Close()
+	defer second.Close()
+
+	cfg := config.Default()
+
+	cfg.Pools = []config.Pool{
+		{Name: "http", Endpoints: []string{"http://localhost:1"}},
+		{Name: "first", Endpoints: []string{"tcp://" + first.Listener.Addr().String()}},
+		{Name: "second", Endpoints: []string{"tcp://" + second.Listener.Addr().String()}},
+	}
+	cfg.Listeners = []config.Listener{{
+		Type: config.ListenerTLSPassthrough,
+		Port: 9443,
+		Pool: "second",
+		SNI:  map[string]string{"*.first.test": "first"},
+	}}
+
+	balancer, _ := newTestBalancer(t, cfg)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go balancer.tcpListeners[0].Serve(ctx, listener)
+
+	for serverName, want := range map[string]string{"api.first.test": "first", "other.test": "second"} {
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true},
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					return net.Dial("tcp", listener.Addr().String())
+				},
+			},
+		}
+
+		resp, err := client.Get("https://" + serverName)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != want {
+			t.Fatalf("unexpected backend for %s: %q", serverName, body)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	// Сервер отвечает адресом клиента из полученного заголовка PROXY
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				header, err := proxyproto.Read(bufio.NewReader(conn))
+				if err != nil {
+					fmt.Fprintf(conn, "error: %v\n", err)
+					return
+				}
+
+				fmt.Fprintf(conn, "v%d %s\n", header.Version, header.Source)
+			}()
+		}
+	}()
+
+	cfg := config.Default()
+
+	cfg.Pools = []config.Pool{
+		{Name: "http", Endpoints: []string{"http://localhost:1"}},
+		{
+			Name:              "upstream",
+			Endpoints:         []string{"tcp://" + upstream.Addr().String()},
+			SendProxyProtocol: config.ProxyProtocolV2,
+		},
+	}
+	cfg.Listeners = []config.Listener{{
+		Type: config.ListenerTCP,
+		Port: 9000,
+		Pool: "upstream",
+		ProxyProtocol: config.ProxyProtocol{
+			TrustedSources: []string{"127.0.0.1"},
+			HeaderTimeout:  config.Duration{Duration: 100 * time.Millisecond},
+		},
+	}}
+
+	balancer, _ := newTestBalancer(t, cfg)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go balancer.tcpListeners[0].Serve(ctx, listener)
+
+	// Без заголовка от доверенного источника используется адрес соединения
+	for header, want := range map[string]string{
+		"PROXY TCP4 203.0.113.7 10.0.0.1 5000 9000\r\n": "v2 203.0.113.7:5000\n",
+		"": "v2 127.0.0.1:",
+	} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+
+		fmt.Fprint(conn, header)
+
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+
+		if err != nil || !strings.HasPrefix(line, want) {
+			t.Fatalf("unexpected source for %q: %q, %v", header, line, err)
+		}
+	}
+}
+
+func TestForwardForwardedHeaders(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"X-Forwarded-For":   r.Header.Get("X-Forwarded-For"),
+			"X-Forwarded-Host":  r.Header.Get("X-Forwarded-Host"),
+			"X-Forwarded-Proto": r.Header.Get("X-Forwarded-Proto"),
+			"Forwarded":         r.Header.Get("Forwarded"),
+		})
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.TrustedProxies = []string{"10.0.0.0/8"}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	tests := []struct {
+		name   string
+		remote string
+		header http.Header
+		want   map[string]string
+	}{
+		{
+			name:   "untrusted source",
+			remote: "203.0.113.9:4000",
+			header: http.Header{
+				"X-Forwarded-For":   {"1.1.1.1"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "203.0.113.9",
+				"X-Forwarded-Host":  "example.com",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         "for=203.0.113.9;host=example.com;proto=http",
+			},
+		},
+		{
+			name:   "trusted chain",
+			remote: "10.0.0.1:4000",
+			header: http.Header{
+				"X-Forwarded-For":   {"1.1.1.1, 198.51.100.2", "10.0.0.2"},
+				"X-Forwarded-Proto": {"https"},
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "198.51.100.2, 10.0.0.2, 10.0.0.1",
+				"X-Forwarded-Host":  "example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         "for=198.51.100.2, for=10.0.0.2, for=10.0.0.1;host=example.com;proto=http",
+			},
+		},
+		{
+			name:   "trusted forwarded",
+			remote: "10.0.0.1:4000",
+			header: http.Header{
+				"Forwarded": {`for="[2001:db8::1]";by=10.0.0.1`},
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "2001:db8::1, 10.0.0.1",
+				"X-Forwarded-Host":  "example.com",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         `for="[2001:db8::1]";by=10.0.0.1, for=10.0.0.1;host=example.com;proto=http`,
+			},
+		},
+		{
+			name:   "forwarded host and proto",
+			remote: "10.0.0.1:4000",
+			header: http.Header{
+				"Forwarded":         {`for=198.51.100.2;proto=https;host="api.example.com", for=10.0.0.2;proto=http`},
+				"X-Forwarded-Proto": {"http"},
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "198.51.100.2, 10.0.0.2, 10.0.0.1",
+				"X-Forwarded-Host":  "api.example.com",
+				"X-Forwarded-Proto": "https",
+				"Forwarded":         `for=198.51.100.2;proto=https;host="api.example.com", for=10.0.0.2;proto=http, for=10.0.0.1;host=example.com;proto=http`,
+			},
+		},
+		{
+			name:   "untrusted forwarded host and proto",
+			remote: "10.0.0.1:4000",
+			header: http.Header{
+				"Forwarded": {"for=1.1.1.1;proto=https;host=evil.example, for=198.51.100.2"},
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "198.51.100.2, 10.0.0.1",
+				"X-Forwarded-Host":  "example.com",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         "for=198.51.100.2, for=10.0.0.1;host=example.com;proto=http",
+			},
+		},
+		{
+			name:   "quoted comma",
+			remote: "10.0.0.1:4000",
+			header: http.Header{
+				"Forwarded": {`for=198.51.100.2;ext="a,b;c";host="example.org"`},
+			},
+			want: map[string]string{
+				"X-Forwarded-For":   "198.51.100.2, 10.0.0.1",
+				"X-Forwarded-Host":  "example.org",
+				"X-Forwarded-Proto": "http",
+				"Forwarded":         `for=198.51.100.2;ext="a,b;c";host="example.org", for=10.0.0.1;host=example.com;proto=http`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/", nil)
+			req.RemoteAddr = tt.remote
+			req.Header = tt.header
+			req.Header.Set("X-API-Key", key)
+			resp := httptest.NewRecorder()
+
+			balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+			var got map[string]string
+
+			if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to parse response: %v", err)
+			}
+
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("unexpected %s: %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardRequestID(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Сервер возвращает полученный идентификатор в заголовке ответа
+		w.Header().Set("X-Trace-ID", r.Header.Get("X-Trace-ID"))
+		fmt.Fprint(w, r.Header.Get("X-Trace-ID"))
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.RequestIDHeader = "x-trace-id"
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	var logs strings.Builder
+
+	balancer.logger = slog.New(requestIDHandler{Handler: slog.NewJSONHandler(&logs, nil)})
+
+	// Идентификатор от клиента передаётся серверу и возвращается в ответе
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", key)
+	req.Header.Set("X-Trace-ID", "trace-123")
+	resp := httptest.NewRecorder()
+
+	balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+	if id := resp.Header().Get("X-Trace-ID"); id != "trace-123" || resp.Body.String() != id {
+		t.Fatalf("unexpected request ID: %q, endpoint got %q", id, resp.Body.String())
+	}
+
+	if ids := resp.Header().Values("X-Trace-ID"); len(ids) != 1 {
+		t.Fatalf("request ID header is duplicated: %q", ids)
+	}
+
+	if !strings.Contains(logs.String(), `"request_id":"trace-123"`) {
+		t.Fatalf("request ID is not logged: %s", logs.String())
+	}
+
+	// Без заголовка создаётся новый идентификатор, который попадает в тело ошибки
+	req = httptest.NewRequest("GET", "/", nil)
+	resp = httptest.NewRecorder()
+
+	balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+	var message ResponseMessage
+
+	if err := json.NewDecoder(resp.Body).Decode(&message); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if message.RequestID == "" || message.RequestID != resp.Header().Get("X-Trace-ID") {
+		t.Fatalf("unexpected request ID: %q, header %q", message.RequestID, resp.Header().Get("X-Trace-ID"))
+	}
+}
+
+func TestForwardAccessLog(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer endpoint.Close()
+
+	path := t.TempDir() + "/access.log"
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.AccessLog = config.AccessLog{Format: config.AccessLogJSON, Path: path}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	for _, key := range []string{key, ""} {
+		req := httptest.NewRequest("GET", "/hello?name=test", nil)
+		req.Header.Set("X-API-Key", key)
+
+		balancer.Forward(balancer.routes[0]).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	balancer.accessLog.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read access log: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("unexpected number of entries: %d", len(lines))
+	}
+
+	var entry map[string]any
+
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("failed to parse entry: %v", err)
+	}
+
+	// В журнал записывается только открытая часть ключа
+	keyID, _, _ := client.ParseKey(key)
+
+	want := map[string]any{
+		"method":     "GET",
+		"path":       "/hello?name=test",
+		"status":     float64(http.StatusOK),
+		"bytes":      float64(len("hello")),
+		"clientKey":  keyID,
+		"clientName": "test-client",
+		"limiter":    "allowed",
+		"endpoint":   strings.TrimPrefix(endpoint.URL, "http://"),
+		"retries":    float64(0),
+	}
+
+	for name, value := range want {
+		if entry[name] != value {
+			t.Errorf("unexpected %s: %v, want %v", name, entry[name], value)
+		}
+	}
+
+	if _, ok := entry["upstreamLatencyMs"]; !ok {
+		t.Errorf("upstream latency is not logged: %s", lines[0])
+	}
+
+	if !strings.Contains(lines[1], `"status":401`) || strings.Contains(lines[1], `"limiter"`) {
+		t.Errorf("unexpected entry for rejected request: %s", lines[1])
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.Admin = config.Admin{Port: 9091}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", key)
+
+	balancer.Forward(balancer.routes[0]).ServeHTTP(httptest.NewRecorder(), req)
+
+	// Имя клиента записывается в метку только при включённой настройке
+	balancer.config.Admin.MetricsClientLabel = true
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", key)
+
+	balancer.Forward(balancer.routes[0]).ServeHTTP(httptest.NewRecorder(), req)
+
+	// Метрики доступны только на служебном слушателе
+	resp := httptest.NewRecorder()
+	balancer.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+
+	if strings.Contains(resp.Body.String(), "balancer_requests_total") {
+		t.Fatal("metrics are served on the public listener")
+	}
+
+	resp = httptest.NewRecorder()
+	balancer.admin.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/metrics", nil))
+
+	host := strings.TrimPrefix(endpoint.URL, "http://")
+
+	for _, want := range []string{
+		fmt.Sprintf(`balancer_requests_total{client="",endpoint=%q,route="/",status="200"}`, host),
+		fmt.Sprintf(`balancer_requests_total{client="test-client",endpoint=%q,route="/",status="200"}`, host),
+		fmt.Sprintf(`balancer_upstream_duration_seconds_count{endpoint=%q,route="/"}`, host),
+		`balancer_request_duration_seconds_count{route="/"}`,
+		`balancer_limiter_decisions_total{client="",result="allowed"}`,
+		`balancer_limiter_decisions_total{client="test-client",result="allowed"}`,
+		fmt.Sprintf(`balancer_endpoint_in_flight{endpoint=%q,pool="default"} 0`, host),
+		fmt.Sprintf(`balancer_endpoint_up{endpoint=%q,pool="default"} 1`, host),
+		`balancer_storage_query_duration_seconds_count{query="has"}`,
+		`balancer_limiter_buckets`,
+	} {
+		if !strings.Contains(resp.Body.String(), want) {
+			t.Errorf("metric is not found: %s", want)
+		}
+	}
+}
+
+func TestAdminListener(t *testing.T) {
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{"http://localhost:8081"}
+	cfg.Admin = config.Admin{Port: 9091, Token: "secret"}
+
+	balancer, key := newTestBalancer(t, cfg)
+	id := testClientID(t, balancer, key)
+
+	if balancer.admin.Addr != ":9091" {
+		t.Errorf("unexpected admin address: %s", balancer.admin.Addr)
+	}
+
+	// Обработчики для клиентов недоступны на публичном сервере
+	resp := httptest.NewRecorder()
+	balancer.server.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/client/"+id, nil))
+
+	if strings.Contains(resp.Body.String(), "test-client") {
+		t.Fatal("client is served on the public listener")
+	}
+
+	for _, tt := range []struct {
+		name          string
+		authorization string
+		code          int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"invalid token", "Bearer invalid", http.StatusUnauthorized},
+		{"invalid scheme", "Basic secret", http.StatusUnauthorized},
+		{"valid token", "Bearer secret", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/client/"+id, nil)
+
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			resp := httptest.NewRecorder()
+			balancer.admin.Handler.ServeHTTP(resp, req)
+
+			if resp.Code != tt.code {
+				t.Fatalf("unexpected code: %d, want %d", resp.Code, tt.code)
+			}
+
+			if tt.code == http.StatusUnauthorized && resp.Header().Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate header is not set")
+			}
+		})
+	}
+
+	// Без аутентификации служебный сервер слушает только локальный адрес
+	cfg = config.Default()
+
+	cfg.Endpoints = []string{"http://localhost:8081"}
+
+	balancer, _ = newTestBalancer(t, cfg)
+
+	if balancer.admin.Addr != "127.0.0.1:9090" {
+		t.Errorf("unexpected admin address without token: %s", balancer.admin.Addr)
+	}
+}
+
+func TestAdminTokens(t *testing.T) {
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{"http://localhost:8081"}
+	cfg.Admin = config.Admin{Port: 9091, Token: "secret"}
+
+	balancer, _ := newTestBalancer(t, cfg)
+
+	var audit bytes.Buffer
+	balancer.audit = slog.New(slog.NewJSONHandler(&audit, nil))
+
+	call := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp := httptest.NewRecorder()
+		balancer.admin.Handler.ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	resp := call("POST", "/tokens", "secret", `{"name":"support","scopes":["clients:read"]}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("failed to add token, got status code: %d", resp.Code)
+	}
+
+	var token ResponseToken
+
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if token.Secret == "" || token.ID == "" {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+
+	if resp := call("GET", "/clients", token.Secret, ""); resp.Code != http.StatusOK {
+		t.Errorf("unexpected code for allowed scope: %d", resp.Code)
+	}
+
+	if resp := call("POST", "/client", token.Secret, `{"name":"new","capacity":1,"rate":1}`); resp.Code != http.StatusForbidden {
+		t.Errorf("unexpected code for missing scope: %d", resp.Code)
+	}
+
+	if resp := call("GET", "/tokens", token.Secret, ""); resp.Code != http.StatusForbidden {
+		t.Errorf("unexpected code for tokens list: %d", resp.Code)
+	}
+
+	resp = call("GET", "/tokens", "secret", "")
+
+	if !strings.Contains(resp.Body.String(), `"name":"support"`) || strings.Contains(resp.Body.String(), token.Secret) {
+		t.Errorf("unexpected tokens list: %s", resp.Body.String())
+	}
+
+	if resp := call("POST", "/tokens", "secret", `{"name":"ops","scopes":["clients:all"]}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected code for unknown scope: %d", resp.Code)
+	}
+
+	// Токен с правом tokens:write не может выдать права, которых у него нет
+	resp = call("POST", "/tokens", "secret", `{"name":"issuer","scopes":["tokens:write"]}`)
+
+	var issuer ResponseToken
+
+	if err := json.NewDecoder(resp.Body).Decode(&issuer); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if resp := call("POST", "/tokens", issuer.Secret, `{"name":"escalated","scopes":["clients:write"]}`); resp.Code != http.StatusForbidden {
+		t.Errorf("unexpected code for scope escalation: %d", resp.Code)
+	}
+
+	if resp := call("POST", "/tokens", issuer.Secret, `{"name":"issued","scopes":["tokens:write"]}`); resp.Code != http.StatusOK {
+		t.Errorf("unexpected code for own scope: %d", resp.Code)
+	}
+
+	if resp := call("DELETE", "/tokens/"+token.ID, "secret", ""); resp.Code != http.StatusOK {
+		t.Fatalf("failed to delete token, got status code: %d", resp.Code)
+	}
+
+	if resp := call("GET", "/clients", token.Secret, ""); resp.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected code for deleted token: %d", resp.Code)
+	}
+
+	for _, want := range []string{
+		fmt.Sprintf(`"path":"/clients","status":200,"remote_addr":"192.0.2.1:1234","token_id":%q,"token_name":"support"`, token.ID),
+		fmt.Sprintf(`"path":"/client","status":403,"remote_addr":"192.0.2.1:1234","token_id":%q`, token.ID),
+		`"path":"/tokens","status":200,"remote_addr":"192.0.2.1:1234","token_id":"root"`,
+	} {
+		if !strings.Contains(audit.String(), want) {
+			t.Errorf("audit entry is not found: %s", want)
+		}
+	}
+}
+
+func TestUpdateClient(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+
+	balancer, key := newTestBalancer(t, cfg)
+	id := testClientID(t, balancer, key)
+
+	forward := func() int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", key)
+
+		resp := httptest.NewRecorder()
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+		return resp.Code
+	}
+
+	// Ведро клиента создаётся при первом запросе
+	if code := forward(); code != http.StatusOK {
+		t.Fatalf("unexpected code: %d", code)
+	}
+
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{}`, http.StatusBadRequest},
+		{`{"capacity":0}`, http.StatusBadRequest},
+		{`{"name":""}`, http.StatusBadRequest},
+		{`{"name":"updated","capacity":2,"rate":1}`, http.StatusOK},
+	} {
+		req := httptest.NewRequest("PATCH", "/client/"+id, strings.NewReader(tt.body))
+		resp := httptest.NewRecorder()
+
+		balancer.admin.Handler.ServeHTTP(resp, req)
+
+		if resp.Code != tt.code {
+			t.Fatalf("unexpected code for %s: %d, want %d", tt.body, resp.Code, tt.code)
+		}
+	}
+
+	resp := httptest.NewRecorder()
+	balancer.admin.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/client/"+id, nil))
+
+	var updated client.Client
+
+	if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if updated.Name != "updated" || updated.Capacity != 2 || updated.Rate != 1 {
+		t.Fatalf("unexpected client: %+v", updated)
+	}
+
+	if name := balancer.limiter.Name(id); name != "updated" {
+		t.Errorf("unexpected bucket name: %s", name)
+	}
+
+	// Токены ограничены новой ёмкостью ведра
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := forward(); code != want {
+			t.Fatalf("unexpected code for request %d: %d, want %d", i, code, want)
+		}
+	}
+
+	req := httptest.NewRequest("PATCH", "/client/"+uuid.NewString(), strings.NewReader(`{"rate":5}`))
+	resp = httptest.NewRecorder()
+
+	balancer.admin.Handler.ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("unexpected code for unknown client: %d", resp.Code)
+	}
+}
+
+func TestDeleteClientRevokesKey(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+
+	balancer, key := newTestBalancer(t, cfg)
+	id := testClientID(t, balancer, key)
+
+	forward := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", key)
+
+		resp := httptest.NewRecorder()
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	if resp := forward(); resp.Code != http.StatusOK {
+		t.Fatalf("unexpected code: %d", resp.Code)
+	}
+
+	resp := httptest.NewRecorder()
+	balancer.admin.Handler.ServeHTTP(resp, httptest.NewRequest("DELETE", "/client/"+id, nil))
+
+	if resp.Code != http.StatusOK {
+		t.Fatalf("failed to delete client, got status code: %d", resp.Code)
+	}
+
+	// Повторные запросы не добавляют ключ в базу данных заново
+	for range 2 {
+		resp := forward()
+
+		if resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "client key is revoked") {
+			t.Fatalf("unexpected response for deleted client: %d %s", resp.Code, resp.Body.String())
+		}
+	}
+
+	resp = httptest.NewRecorder()
+	balancer.admin.Handler.ServeHTTP(resp, httptest.NewRequest("GET", "/client/"+id, nil))
+
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("deleted client is found: %d", resp.Code)
+	}
+}
+
+func TestForwardUnknownClients(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	for _, tt := range []struct {
+		action     string
+		codes      []int
+		registered bool
+	}{
+		{config.UnknownClientsReject, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized}, false},
+		{config.UnknownClientsRegister, []int{http.StatusOK, http.StatusOK, http.StatusOK}, true},
+		// Общее ведро ёмкостью 2 на все неизвестные ключи
+		{config.UnknownClientsAnonymous, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, false},
+	} {
+		t.Run(tt.action, func(t *testing.T) {
+			cfg := config.Default()
+
+			cfg.Endpoints = []string{endpoint.URL}
+			cfg.UnknownClients = tt.action
+			cfg.Defaults = config.Defaults{Capacity: 2, Rate: 1}
+
+			balancer, key := newTestBalancer(t, cfg)
+
+			keys := []string{uuid.NewString(), uuid.NewString(), uuid.NewString()}
+
+			for i, unknown := range keys {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("X-API-Key", unknown)
+
+				resp := httptest.NewRecorder()
+				balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+				if resp.Code != tt.codes[i] {
+					t.Fatalf("unexpected code for request %d: %d, want %d", i, resp.Code, tt.codes[i])
+				}
+			}
+
+			_, _, err := balancer.clients.FindByKey(context.Background(), keys[0])
+
+			if registered := err == nil; registered != tt.registered {
+				t.Errorf("unexpected registration of unknown key: %v", registered)
+			}
+
+			// Известный клиент использует собственное ведро
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-API-Key", key)
+
+			resp := httptest.NewRecorder()
+			balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Errorf("unexpected code for known client: %d", resp.Code)
+			}
+		})
+	}
+}
+
+func TestUnknownKeyLookups(t *testing.T) {
+	for _, action := range []string{config.UnknownClientsReject, config.UnknownClientsAnonymous} {
+		t.Run(action, func(t *testing.T) {
+			cfg := config.Default()
+
+			cfg.Endpoints = []string{"http://localhost:8081"}
+			cfg.UnknownClients = action
+
+			balancer, _ := newTestBalancer(t, cfg)
+
+			// Запросы к хранилищу считаются по спанам
+			exporter := tracetest.NewInMemoryExporter()
+			tracer := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(tracing.Name)
+
+			lookups := func() int {
+				var n int
+
+				for _, span := range exporter.GetSpans() {
+					if span.Name == "storage.find_by_key" {
+						n++
+					}
+				}
+
+				return n
+			}
+
+			unknown := client.GenerateKey()
+
+			for range 5 {
+				ctx, span := tracer.Start(context.Background(), "request")
+				balancer.limiter.Authenticate(ctx, unknown)
+				span.End()
+			}
+
+			// Неизвестный ключ проверяется в базе данных только один раз
+			if n := lookups(); n != 1 {
+				t.Fatalf("unexpected number of storage lookups: %d", n)
+			}
+
+			ctx, span := tracer.Start(context.Background(), "request")
+			id, err := balancer.limiter.Authenticate(ctx, client.GenerateKey())
+			span.End()
+
+			if n := lookups(); n != 2 {
+				t.Fatalf("another unknown key is not checked: %d lookups", n)
+			}
+
+			switch action {
+			case config.UnknownClientsAnonymous:
+				if id != limiter.AnonymousID || err != nil {
+					t.Errorf("unexpected result for unknown key: %q, %v", id, err)
+				}
+			default:
+				if !errors.Is(err, limiter.ErrUnknownClient) {
+					t.Errorf("unexpected error for unknown key: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardTracing(t *testing.T) {
+	var traceparent string
+
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("Traceparent")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+
+	balancer, key := newTestBalancer(t, cfg)
+
+	exporter := tracetest.NewInMemoryExporter()
+	balancer.tracer = sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)).Tracer(tracing.Name)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", key)
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+
+	balancer.Forward(balancer.routes[0]).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := make(map[string]tracetest.SpanStub)
+
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID().String() != traceID {
+			t.Errorf("span %q has unexpected trace ID: %s", span.Name, span.SpanContext.TraceID())
+		}
+
+		spans[span.Name] = span
+	}
+
+	// Ключ клиента ещё не проверялся, поэтому лимитер обращается к хранилищу
+	parents := map[string]string{
+		"limiter.Authenticate": "Forward /",
+		"storage.has":          "limiter.Authenticate",
+		"storage.find_by_key":  "storage.has",
+		"limiter.Available":    "Forward /",
+		"pool.Next":            "Forward /",
+		"upstream":             "Forward /",
+	}
+
+	for name, parent := range parents {
+		span, ok := spans[name]
+		if !ok {
+			t.Fatalf("span %q is not found", name)
+		}
+
+		if span.Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+			t.Errorf("span %q is not a child of %q", name, parent)
+		}
+	}
+
+	want := fmt.Sprintf("00-%s-%s-01", traceID, spans["upstream"].SpanContext.SpanID())
+
+	if traceparent != want {
+		t.Fatalf("unexpected traceparent: %q, want %q", traceparent, want)
+	}
+}
+
+func TestHashedClientKeys(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+	cfg.LoggingLevel = "none"
+	cfg.MigrationsPath = "./../../migrations"
+	cfg.FilePath = t.TempDir() + "/clients.sqlite"
+	cfg.UnknownClients = config.UnknownClientsReject
+
+	// База данных с ключами в открытом виде, созданная до хеширования ключей
+	db, err := sql.Open("sqlite", cfg.FilePath)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+
+	if err := goose.SetDialect("sqlite"); err != nil {
+		t.Fatalf("failed to set dialect: %v", err)
+	}
+
+	if err := goose.UpTo(db, cfg.MigrationsPath, 202505101000); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	legacy := uuid.NewString()
+
+	_, err = db.Exec("INSERT INTO clients (api_key, name, capacity, rate) VALUES ($1, 'legacy', 10, 1)", legacy)
+	if err != nil {
+		t.Fatalf("failed to add legacy client: %v", err)
+	}
+	defer db.Close()
+
+	balancer, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create a balancer: %v", err)
+	}
+
+	resp := httptest.NewRecorder()
+	balancer.AddClient().ServeHTTP(resp, httptest.NewRequest("POST", "/client", strings.NewReader(`{"name":"new","capacity":10,"rate":1}`)))
+
+	var created ResponseKey
+
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if !strings.HasPrefix(created.Key, client.KeyPrefix) || uuid.Validate(created.ID) != nil {
+		t.Fatalf("unexpected created client: %+v", created)
+	}
+
+	for _, key := range []string{legacy, created.Key} {
+		var stored int
+
+		err := db.QueryRow(
+			"SELECT COUNT(*) FROM api_keys WHERE key_hash = $1 OR key_salt = $1 OR id = $1", key).
+			Scan(&stored)
+		if err != nil {
+			t.Fatalf("failed to query clients: %v", err)
+		}
+
+		if stored != 0 {
+			t.Errorf("key %s is stored in plain text", key)
+		}
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", key)
+
+		resp := httptest.NewRecorder()
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusOK {
+			t.Errorf("unexpected code for key %s: %d", key, resp.Code)
+		}
+	}
+
+	// Ключ, перенесённый из таблицы clients, доступен в списке ключей клиента
+	legacyClient, legacyKey, err := balancer.clients.FindByKey(context.Background(), legacy)
+	if err != nil {
+		t.Fatalf("failed to find legacy client: %v", err)
+	}
+
+	keys, err := balancer.clients.ListKeys(context.Background(), legacyClient.ID)
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].ID != legacyKey.ID || keys[0].CreatedAt.IsZero() {
+		t.Errorf("unexpected legacy keys: %+v", keys)
+	}
+
+	// Открытая часть старого ключа не содержит символов самого ключа
+	if !strings.HasPrefix(legacyKey.ID, "legacy_") || strings.Contains(legacyKey.ID, legacy[:8]) {
+		t.Errorf("unexpected legacy key id: %s", legacyKey.ID)
+	}
+
+	// Ключ с верной открытой частью, но другой секретной частью
+	id, _, _ := client.ParseKey(created.Key)
+	forged := id + "_" + strings.Repeat("A", 26)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-API-Key", forged)
+
+	resp = httptest.NewRecorder()
+	balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("unexpected code for forged key: %d", resp.Code)
+	}
+
+	// В режиме register такой ключ также отклоняется и не добавляется в базу данных
+	balancer.limiter = limiter.New(*balancer.clients.(*client.DatabaseStorage), config.UnknownClientsRegister)
+
+	for range 2 {
+		resp = httptest.NewRecorder()
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "client key is not valid") {
+			t.Errorf("unexpected response for forged key in register mode: %d %s", resp.Code, resp.Body.String())
+		}
+	}
+
+	var clients int
+
+	if err := db.QueryRow("SELECT COUNT(*) FROM clients").Scan(&clients); err != nil {
+		t.Fatalf("failed to count clients: %v", err)
+	}
+
+	if clients != 2 {
+		t.Errorf("unexpected number of clients: %d", clients)
+	}
+}
+
+func TestClientKeyRotation(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+
+	balancer, first := newTestBalancer(t, cfg)
+	id := testClientID(t, balancer, first)
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		balancer.admin.Handler.ServeHTTP(resp, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		return resp
+	}
+
+	forward := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", key)
+
+		resp := httptest.NewRecorder()
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+		return resp
+	}
+
+	if resp := admin("PATCH", "/client/"+id, `{"capacity":3,"rate":1}`); resp.Code != http.StatusOK {
+		t.Fatalf("failed to update client: %d", resp.Code)
+	}
+
+	resp := admin("POST", "/client/"+id+"/keys", "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("failed to add key: %d", resp.Code)
+	}
+
+	var second ResponseAPIKey
+
+	if err := json.NewDecoder(resp.Body).Decode(&second); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if second.ClientID != id || !strings.HasPrefix(second.Key, second.ID+"_") {
+		t.Fatalf("unexpected key: %+v", second)
+	}
+
+	// Ключи клиента используют одно ведро
+	for i, tt := range []struct {
+		key  string
+		code int
+	}{
+		{first, http.StatusOK},
+		{second.Key, http.StatusOK},
+		{first, http.StatusOK},
+		{second.Key, http.StatusTooManyRequests},
+	} {
+		if resp := forward(tt.key); resp.Code != tt.code {
+			t.Fatalf("unexpected code for request %d: %d, want %d", i, resp.Code, tt.code)
+		}
+	}
+
+	if err := balancer.limiter.FlushUsage(context.Background()); err != nil {
+		t.Fatalf("failed to save key usage: %v", err)
+	}
+
+	firstID, _, _ := client.ParseKey(first)
+
+	if resp := admin("DELETE", "/client/"+id+"/keys/"+firstID, ""); resp.Code != http.StatusOK {
+		t.Fatalf("failed to delete key: %d", resp.Code)
+	}
+
+	if resp := forward(first); resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "client key is revoked") {
+		t.Fatalf("unexpected response for revoked key: %d %s", resp.Code, resp.Body.String())
+	}
+
+	// Отзыв ключа не пополняет ведро клиента
+	if resp := forward(second.Key); resp.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected code for second key: %d", resp.Code)
+	}
+
+	resp = admin("GET", "/client/"+id+"/keys", "")
+
+	var keys []client.APIKey
+
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].ID != second.ID || keys[0].LastUsedAt == nil || keys[0].CreatedAt.IsZero() {
+		t.Fatalf("unexpected keys: %+v", keys)
+	}
+
+	if resp := admin("POST", "/client/"+id+"/keys", `{"expiresAt":"2020-01-01T00:00:00Z"}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected code for past expiration time: %d", resp.Code)
+	}
+
+	expiresAt := time.Now().Add(100 * time.Millisecond).Format(time.RFC3339Nano)
+
+	resp = admin("POST", "/client/"+id+"/keys", fmt.Sprintf(`{"expiresAt":%q}`, expiresAt))
+
+	var expiring ResponseAPIKey
+
+	if err := json.NewDecoder(resp.Body).Decode(&expiring); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if resp := forward(expiring.Key); resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), "client key is expired") {
+		t.Fatalf("unexpected response for expired key: %d %s", resp.Code, resp.Body.String())
+	}
+
+	if resp := admin("DELETE", "/client/"+id+"/keys/"+firstID, ""); resp.Code != http.StatusNotFound {
+		t.Errorf("unexpected code for deleted key: %d", resp.Code)
+	}
+}
+
+func TestClientStatus(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer endpoint.Close()
+
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{endpoint.URL}
+
+	balancer, key := newTestBalancer(t, cfg)
+	id := testClientID(t, balancer, key)
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		balancer.admin.Handler.ServeHTTP(resp, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		return resp
+	}
+
+	forward := func(key, message string) {
+		t.Helper()
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-API-Key", key)
+
+		resp := httptest.NewRecorder()
+		balancer.Forward(balancer.routes[0]).ServeHTTP(resp, req)
+
+		if message == "" && resp.Code != http.StatusOK {
+			t.Fatalf("unexpected response: %d %s", resp.Code, resp.Body.String())
+		}
+
+		if message != "" && (resp.Code != http.StatusUnauthorized || !strings.Contains(resp.Body.String(), message)) {
+			t.Fatalf("unexpected response: %d %s, want %q", resp.Code, resp.Body.String(), message)
+		}
+	}
+
+	forward(key, "")
+
+	if resp := admin("PATCH", "/client/"+id, `{"disabled":true}`); resp.Code != http.StatusOK {
+		t.Fatalf("failed to disable client: %d", resp.Code)
+	}
+
+	// Ведро отключённого клиента удаляется, но его параметры сохраняются
+	if name := balancer.limiter.Name(id); name != "" {
+		t.Errorf("bucket of disabled client is not removed: %s", name)
+	}
+
+	forward(key, "client is disabled")
+
+	admin("PATCH", "/client/"+id, `{"disabled":false}`)
+	forward(key, "")
+
+	expiresAt := time.Now().Add(100 * time.Millisecond).Format(time.RFC3339Nano)
+	admin("PATCH", "/client/"+id, fmt.Sprintf(`{"expiresAt":%q}`, expiresAt))
+	forward(key, "")
+
+	time.Sleep(150 * time.Millisecond)
+	forward(key, "client is expired")
+
+	// Явный null сбрасывает срок действия клиента
+	resp := admin("PATCH", "/client/"+id, `{"expiresAt":null}`)
+	if resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), "expiresAt") {
+		t.Fatalf("expiration time is not cleared: %d %s", resp.Code, resp.Body.String())
+	}
+
+	forward(key, "")
+
+	expiresAt = time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	admin("PATCH", "/client/"+id, fmt.Sprintf(`{"expiresAt":%q}`, expiresAt))
+	forward(key, "")
+
+	resp = admin("POST", "/client/"+id+"/keys", fmt.Sprintf(`{"expiresAt":%q}`, expiresAt))
+
+	var second ResponseAPIKey
+
+	if err := json.NewDecoder(resp.Body).Decode(&second); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	forward(second.Key, "")
+
+	if resp := admin("PATCH", "/client/"+id+"/keys/"+second.ID, `{"disabled":true}`); resp.Code != http.StatusOK {
+		t.Fatalf("failed to disable key: %d", resp.Code)
+	}
+
+	forward(second.Key, "client key is disabled")
+	forward(key, "")
+
+	if resp := admin("PATCH", "/client/"+id+"/keys/"+second.ID, `{}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("unexpected code for empty update: %d", resp.Code)
+	}
+
+	// Срок действия второго ключа истекает через час
+	admin("PATCH", "/client/"+id+"/keys/"+second.ID, `{"disabled":false}`)
+
+	resp = admin("GET", "/keys/expiring", "")
+
+	var report ExpiringKeys
+
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	if len(report.Keys) != 1 || report.Keys[0].ID != second.ID || report.Keys[0].ClientID != id {
+		t.Fatalf("unexpected expiring keys: %+v", report)
+	}
+
+	// Явный null сбрасывает срок действия ключа
+	resp = admin("PATCH", "/client/"+id+"/keys/"+second.ID, `{"expiresAt":null}`)
+	if resp.Code != http.StatusOK || strings.Contains(resp.Body.String(), "expiresAt") {
+		t.Fatalf("key expiration time is not cleared: %d %s", resp.Code, resp.Body.String())
+	}
+
+	forward(second.Key, "")
+}
+
+func TestGetClientsList(t *testing.T) {
+	cfg := config.Default()
+
+	cfg.Endpoints = []string{"http://localhost:8081"}
+
+	balancer, _ := newTestBalancer(t, cfg)
+
+	admin := func(method, path, body string) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		balancer.admin.Handler.ServeHTTP(resp, httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		return resp
+	}
+
+	list := func(query string) client.Page {
+		t.Helper()
+
+		resp := admin("GET", "/clients?"+query, "")
+		if resp.Code != http.StatusOK {
+			t.Fatalf("unexpected code for %q: %d %s", query, resp.Code, resp.Body.String())
+		}
+
+		var page client.Page
+
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatalf("failed to parse response: %v", err)
+		}
+
+		return page
+	}
+
+	for i, name := range []string{"Alpha", "beta", "gamma", "delta", "alphabet"} {
+		body := fmt.Sprintf(`{"name":%q,"capacity":%d,"rate":%d}`, name, (i+1)*10, i+1)
+
+		if resp := admin("POST", "/client", body); resp.Code != http.StatusOK {
+			t.Fatalf("failed to add client: %d", resp.Code)
+		}
+	}
+
+	after := time.Now()
+
+	resp := admin("POST", "/client", `{"name":"disabled","capacity":5,"rate":1}`)
+
+	var created ResponseKey
+
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to parse response: %v", err)
+	}
+
+	admin("PATCH", "/client/"+created.ID, `{"disabled":true}`)
+
+	// Все страницы по имени в обратном порядке без пропусков и повторов
+	var names []string
+
+	cursor := ""
+
+	for range 10 {
+		page := list("limit=2&sort=name&order=desc&cursor=" + cursor)
+
+		if page.Total != 7 {
+			t.Fatalf("unexpected total: %d", page.Total)
+		}
+
+		for _, c := range page.Clients {
+			names = append(names, c.Name)
+		}
+
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+
+	want := []string{"test-client", "gamma", "disabled", "delta", "beta", "alphabet", "Alpha"}
+
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected clients: %v, want %v", names, want)
+	}
+
+	// По умолчанию клиенты сортируются по времени создания
+	page := list("limit=3")
+	next := list("limit=3&cursor=" + page.NextCursor)
+
+	if len(page.Clients) != 3 || page.Clients[0].Name != "test-client" || len(next.Clients) != 3 || next.Clients[0].Name != "gamma" {
+		t.Fatalf("unexpected pages by creation time: %+v %+v", page.Clients, next.Clients)
+	}
+
+	for _, tt := range []struct {
+		query string
+		names []string
+	}{
+		{"name=ALPHA&sort=name", []string{"Alpha", "alphabet"}},
+		{"minCapacity=20&maxCapacity=40&sort=capacity", []string{"beta", "gamma", "delta"}},
+		{"minRate=4&maxRate=5&sort=rate&order=desc", []string{"alphabet", "delta"}},
+		{"disabled=true", []string{"disabled"}},
+		{"createdAfter=" + url.QueryEscape(after.Format(time.RFC3339Nano)), []string{"disabled"}},
+		{"name=%25", []string{}},
+	} {
+		page := list(tt.query)
+
+		names := make([]string, 0)
+
+		for _, c := range page.Clients {
+			names = append(names, c.Name)
+		}
+
+		if strings.Join(names, ",") != strings.Join(tt.names, ",") || page.Total != len(tt.names) {
+			t.Errorf("unexpected clients for %q: %v (total %d), want %v", tt.query, names, page.Total, tt.names)
+		}
+	}
+
+	// Пустой список возвращается в виде массива, а не null
+	if resp := admin("GET", "/clients?name=unknown", ""); !strings.Contains(resp.Body.String(), `"clients":[]`) {
+		t.Errorf("unexpected empty list: %s", resp.Body.String())
+	}
+
+	first := list("limit=1&sort=name")
+
+	for _, query := range []string{
+		"limit=0",
+		"limit=5000",
+		"sort=unknown",
+		"order=random",
+		"minRate=5&maxRate=1",
+		"disabled=maybe",
+		"createdAfter=yesterday",
+		"cursor=invalid",
+		// Курсор другой сортировки
+		"sort=rate&cursor=" + first.NextCursor,
+	} {
+		if resp := admin("GET", "/clients?"+query, ""); resp.Code != http.StatusBadRequest {
+			t.Errorf("unexpected code for %q: %d", query, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Префиксы идентификаторов сертификата клиента в таблице clients
+const (
+	certificateFingerprint = "sha256:"
+	certificateSubject     = "subject:"
+	certificateSAN         = "san:"
+)
+
+// Ошибка для запросов, в которых нет проверенного сертификата клиента
+var errNoCertificate = errors.New("no verified client certificate")
+
+// Ошибка для запросов без ключа и без сертификата, привязанного к клиенту
+var errNoCredentials = errors.New("no client credentials")
+
+// Проверяет формат идентификатора сертификата для привязки к клиенту
+func validCertificateIdentity(identity string) bool {
+	for _, prefix := range []string{certificateFingerprint, certificateSubject, certificateSAN} {
+		if value, ok := strings.CutPrefix(identity, prefix); ok {
+			return value != ""
+		}
+	}
+
+	return false
+}
+
+// Возвращает все идентификаторы сертификата, по которым может быть
+// найден клиент: отпечаток SHA-256, субъект и альтернативные имена
+func certificateIdentities(cert *x509.Certificate) []string {
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	identities := []string{
+		certificateFingerprint + hex.EncodeToString(fingerprint[:]),
+		certificateSubject + cert.Subject.String(),
+	}
+
+	for _, name := range cert.DNSNames {
+		identities = append(identities, certificateSAN+name)
+	}
+
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, certificateSAN+email)
+	}
+
+	for _, uri := range cert.URIs {
+		identities = append(identities, certificateSAN+uri.String())
+	}
+
+	return identities
+}
+
+// Возвращает идентификатор клиента по ключу из заголовка X-API-Key,
+// а без ключа - по проверенному сертификату клиента
+func (b *Balancer) clientID(r *http.Request, key string) (string, error) {
+	if key != "" {
+		return b.limiter.Authenticate(r.Context(), key)
+	}
+
+	if r.TLS == nil {
+		return "", errNoCredentials
+	}
+
+	id, err := b.certificateClient(r)
+	if err != nil {
+		if !errors.Is(err, errNoCertificate) {
+			b.logger.DebugContext(r.Context(), "find client by certificate", "err", err)
+		}
+
+		return "", errNoCredentials
+	}
+
+	return id, nil
+}
+
+// Возвращает идентификатор клиента, к которому привязан проверенный сертификат из запроса.
+// Найденные клиенты сохраняются по отпечатку сертификата, чтобы не обращаться к базе данных
+func (b *Balancer) certificateClient(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", errNoCertificate
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	if id, ok := b.certificateClients.Load(fingerprint); ok {
+		return id.(string), nil
+	}
+
+	client, err := b.clients.FindByCertificate(r.Context(), certificateIdentities(cert))
+	if err != nil {
+		return "", err
+	}
+
+	b.certificateClients.Store(fingerprint, client.ID)
+
+	return client.ID, nil
+}
+
+// Очищает сохранённых клиентов для сертификатов после изменения привязок
+func (b *Balancer) resetCertificates() {
+	b.certificateClients.Clear()
+}
+
+func (b *Balancer) BindCertificate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Certificate string `json:"certificate"`
+		}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			ResponseError(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if !validCertificateIdentity(body.Certificate) {
+			ResponseError(w, "invalid certificate identity", http.StatusBadRequest)
+			return
+		}
+
+		bound, err := b.clients.FindByCertificate(r.Context(), []string{body.Certificate})
+		if err == nil && bound.ID != id {
+			ResponseError(w, "certificate is bound to another client", http.StatusConflict)
+			return
+		}
+
+		err = b.clients.BindCertificate(r.Context(), id, body.Certificate)
+		if err != nil {
+			b.logger.Error("bind certificate", "id", id, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "client is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to bind a certificate", http.StatusInternalServerError)
+			return
+		}
+
+		b.resetCertificates()
+
+		b.logger.Info("bind certificate", "id", id, "certificate", body.Certificate)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func (b *Balancer) UnbindCertificate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
+			return
+		}
+
+		err := b.clients.BindCertificate(r.Context(), id, "")
+		if err != nil {
+			b.logger.Error("unbind certificate", "id", id, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "client is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to unbind a certificate", http.StatusInternalServerError)
+			return
+		}
+
+		b.resetCertificates()
+
+		b.logger.Info("unbind certificate", "id", id)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -13,48 +14,67 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/accesslog"
+	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/metrics"
+	"github.com/imotkin/http-balancer/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type Endpoint struct {
 	id          uuid.UUID
 	proxy       *httputil.ReverseProxy
+	streamProxy *httputil.ReverseProxy
 	active      atomic.Bool
 	url         *url.URL
 	tick        <-chan time.Time
 	mu          sync.RWMutex
 	cancel      chan struct{}
 	client      *http.Client
+	transport   *http.Transport
+	dialer      *net.Dialer
 	connections atomic.Int64
 	logger      *slog.Logger
+
+	// Метрики сервера с метками группы и адреса
+	inFlight prometheus.Gauge
+	up       prometheus.Gauge
+	pool     string
 }
 
-func NewEndpoint(URL string, healthInterval time.Duration, logLevel slog.Level) (*Endpoint, error) {
+func NewEndpoint(URL string, pool config.Pool, healthInterval time.Duration, logLevel slog.Level) (*Endpoint, error) {
 	url, err := url.Parse(URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse URL: %w", err)
 	}
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: logLevel,
-	}))
-
-	proxy := httputil.NewSingleHostReverseProxy(url)
+	logger := slog.New(requestIDHandler{
+		Handler: slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+			Level: logLevel,
+		}),
+	})
 
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		w.WriteHeader(http.StatusServiceUnavailable)
-		logger.Error("proxy error", "err", err)
+	endpoint := &Endpoint{
+		id:       uuid.New(),
+		url:      url,
+		tick:     time.Tick(healthInterval),
+		cancel:   make(chan struct{}),
+		logger:   logger,
+		inFlight: metrics.InFlight.WithLabelValues(pool.Name, url.Host),
+		up:       metrics.EndpointUp.WithLabelValues(pool.Name, url.Host),
+		pool:     pool.Name,
 	}
 
-	endpoint := &Endpoint{
-		id:     uuid.New(),
-		proxy:  proxy,
-		url:    url,
-		tick:   time.Tick(healthInterval),
-		cancel: make(chan struct{}),
-		client: &http.Client{
-			Timeout: healthInterval,
-		},
-		logger: logger,
+	// Для серверов с адресами tcp:// соединения передаются без разбора HTTP,
+	// а проверка здоровья выполняется установкой TCP-соединения
+	if url.Scheme == "tcp" {
+		endpoint.dialer = NewDialer(pool.Transport)
+	} else {
+		err = endpoint.setProxy(pool, healthInterval)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	endpoint.Enable()
@@ -64,30 +84,147 @@ func NewEndpoint(URL string, healthInterval time.Duration, logLevel slog.Level)
 	return endpoint, nil
 }
 
+// Создаёт HTTP-транспорт и обратные прокси для сервера
+func (e *Endpoint) setProxy(pool config.Pool, healthInterval time.Duration) error {
+	url, logger := e.url, e.logger
+
+	protocol := pool.Protocol
+
+	// Схема h2c:// означает HTTP/2 без шифрования для отдельного сервера
+	if url.Scheme == "h2c" {
+		url.Scheme = "http"
+		protocol = config.ProtocolHTTP2
+	}
+
+	tlsConfig, err := NewUpstreamTLS(pool.TLS)
+	if err != nil {
+		return fmt.Errorf("configure TLS: %w", err)
+	}
+
+	transport := NewTransport(pool.Transport, protocol, tlsConfig)
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(url)
+
+			// Серверу передаётся исходный заголовок Host
+			pr.Out.Host = pr.In.Host
+
+			setForwarded(pr)
+
+			// Контекст трассировки передаётся в заголовках traceparent и tracestate
+			tracing.Propagator.Inject(pr.Out.Context(), propagation.HeaderCarrier(pr.Out.Header))
+		},
+		Transport: transport,
+	}
+
+	// Время ответа сервера отмечается при получении заголовков или ошибки
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		if entry := accesslog.FromContext(resp.Request.Context()); entry != nil {
+			entry.EndUpstream()
+		}
+
+		// Идентификатор запроса уже задан в ответе клиенту, поэтому копия
+		// от сервера удаляется, чтобы заголовок не повторялся
+		if header := requestIDHeader(resp.Request.Context()); header != "" {
+			resp.Header.Del(header)
+		}
+
+		return nil
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		if entry := accesslog.FromContext(r.Context()); entry != nil {
+			entry.EndUpstream()
+		}
+
+		if isTimeout(err) {
+			logger.ErrorContext(r.Context(), "proxy timeout", "url", url, "err", err)
+			responseErrorFor(w, r, "endpoint timeout", http.StatusGatewayTimeout)
+			return
+		}
+
+		logger.ErrorContext(r.Context(), "proxy error", "url", url, "err", err)
+		responseErrorFor(w, r, "endpoint is unavailable", http.StatusServiceUnavailable)
+	}
+
+	// Для потоковых маршрутов данные отправляются клиенту сразу после получения
+	streamProxy := *proxy
+	streamProxy.FlushInterval = -1
+
+	e.proxy = proxy
+	e.streamProxy = &streamProxy
+	e.transport = transport
+	e.client = &http.Client{
+		Timeout:   healthInterval,
+		Transport: transport,
+	}
+
+	return nil
+}
+
+// Выполняет одну проверку доступности сервера
+func (e *Endpoint) ping(timeout time.Duration) (err error) {
+	defer func(start time.Time) {
+		result := "success"
+
+		if err != nil {
+			result = "failure"
+		}
+
+		metrics.HealthCheckDuration.
+			WithLabelValues(e.pool, e.url.Host, result).
+			Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	// Для tcp:// проверяется установка соединения тем же dialer, что и для
+	// соединений клиентов, с учётом настроек транспорта группы
+	if e.dialer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		conn, err := e.dialer.DialContext(ctx, "tcp", e.url.Host)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close()
+	}
+
+	resp, err := e.client.Get(e.url.String())
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
 func (e *Endpoint) pingEndpoint(cancel <-chan struct{}, attempts int, timeout time.Duration) bool {
 	var current int
 
 	for {
 		select {
 		case <-time.Tick(timeout):
-			resp, err := e.client.Get(e.url.String())
+			err := e.ping(timeout)
 			if err != nil {
 				if current < attempts {
 					current++
 					continue
 				}
 
-				e.logger.Info("ping failed", "id", e.id, "current", current+1, "attempts", attempts)
+				e.logger.Info("ping failed", "id", e.id, "current", current+1, "attempts", attempts, "err", err)
 
 				return false
 			}
 
-			resp.Body.Close()
-
-			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-				e.logger.Info("ping succeeded", "id", e.id, "current", current+1, "attempts", attempts)
-				return true
-			}
+			e.logger.Info("ping succeeded", "id", e.id, "current", current+1, "attempts", attempts)
+			return true
 		case <-cancel:
 			e.logger.Info("stop ping process", "id", e.id)
 		}
@@ -126,27 +263,31 @@ func (e *Endpoint) IsActive() bool {
 
 func (e *Endpoint) Enable() {
 	e.active.Store(true)
+	e.up.Set(1)
 }
 
 func (e *Endpoint) Disable() {
 	e.active.Store(false)
+	e.up.Set(0)
 }
 
 func (e *Endpoint) Connections() int64 {
 	return e.connections.Load()
 }
 
-func (e *Endpoint) NewConnection(ctx context.Context) {
-	select {
-	case <-ctx.Done():
-		return
-	default:
-	}
-
+// Увеличивает количество активных соединений с сервером. Соединение учитывается
+// до вызова ReleaseConnection, в том числе всё время жизни соединения после смены протокола
+func (e *Endpoint) NewConnection() {
 	e.connections.Add(1)
+	e.inFlight.Inc()
+}
 
-	go func() {
-		<-ctx.Done()
-		e.connections.Add(-1)
-	}()
+func (e *Endpoint) ReleaseConnection() {
+	e.connections.Add(-1)
+	e.inFlight.Dec()
+}
+
+// Устанавливает TCP-соединение с сервером для режима передачи без разбора HTTP
+func (e *Endpoint) Dial(ctx context.Context) (net.Conn, error) {
+	return e.dialer.DialContext(ctx, "tcp", e.url.Host)
 }
@@ -0,0 +1,304 @@
+package balancer
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+)
+
+// Ключ контекста для сведений о цепочке прокси запроса
+type forwardedKey struct{}
+
+// Узел цепочки прокси из заголовков Forwarded или X-Forwarded-For
+type hop struct {
+	// Адрес узла (значение параметра for)
+	node string
+
+	// Параметры host и proto элемента Forwarded, которые описывают
+	// запрос, полученный прокси от этого узла
+	host  string
+	proto string
+
+	// Параметры элемента Forwarded кроме for в исходном виде, например, by=10.0.0.1
+	params []string
+}
+
+// Сведения о клиенте и цепочке прокси, которые передаются серверу
+type forwarded struct {
+	// Адрес клиента, определённый с учётом доверенных прокси
+	client string
+
+	// Узлы цепочки начиная с клиента, включая непосредственного отправителя запроса
+	hops []hop
+
+	host  string
+	proto string
+}
+
+// Определяет адрес клиента по цепочке прокси. Заголовки X-Forwarded-*
+// и Forwarded учитываются только от доверенных прокси, а узлы левее
+// первого недоверенного адреса отбрасываются как возможно подделанные.
+// Исходные host и proto берутся из самого левого доверенного элемента
+// Forwarded, а если их там нет, то из X-Forwarded-Host и X-Forwarded-Proto
+func (b *Balancer) forwardedFor(r *http.Request) *forwarded {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	proto := "http"
+
+	if r.TLS != nil {
+		proto = "https"
+	}
+
+	info := &forwarded{
+		client: peer,
+		host:   r.Host,
+		proto:  proto,
+	}
+
+	if !b.trustedProxy(peer) {
+		info.hops = []hop{{node: peer}}
+		return info
+	}
+
+	hops := parseForwarded(r.Header.Values("Forwarded"))
+
+	if len(hops) == 0 {
+		hops = parseForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+
+	hops = append(hops, hop{node: peer})
+
+	// Клиент - первый справа адрес, который не принадлежит доверенным прокси
+	start := 0
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		if !b.trustedProxy(hops[i].node) {
+			start = i
+			break
+		}
+	}
+
+	info.hops = hops[start:]
+	info.client = info.hops[0].node
+
+	// Узлы левее клиента уже отброшены как возможно подделанные, поэтому значения
+	// берутся из самого левого элемента, в котором они заданы
+	var forwardedHost, forwardedProto string
+
+	for _, h := range info.hops {
+		if forwardedHost == "" {
+			forwardedHost = h.host
+		}
+
+		if forwardedProto == "" {
+			forwardedProto = h.proto
+		}
+	}
+
+	if forwardedHost == "" {
+		forwardedHost = r.Header.Get("X-Forwarded-Host")
+	}
+
+	if forwardedProto == "" {
+		forwardedProto = r.Header.Get("X-Forwarded-Proto")
+	}
+
+	if forwardedHost != "" {
+		info.host = forwardedHost
+	}
+
+	if forwardedProto != "" {
+		info.proto = forwardedProto
+	}
+
+	return info
+}
+
+// Проверяет, что адрес принадлежит доверенным прокси
+func (b *Balancer) trustedProxy(node string) bool {
+	addr, err := netip.ParseAddr(strings.Trim(node, "[]"))
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+
+	for _, prefix := range b.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Сохраняет сведения о цепочке прокси в контексте запроса
+func withForwarded(r *http.Request, info *forwarded) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), forwardedKey{}, info))
+}
+
+// Устанавливает заголовки X-Forwarded-* и Forwarded для запроса к серверу.
+// Входящие заголовки уже удалены из исходящего запроса в httputil.ReverseProxy.
+// Доверенные элементы Forwarded передаются без изменений, а добавленный элемент
+// описывает запрос, полученный балансировщиком. Исходный хост из цепочки прокси
+// передаётся только в X-Forwarded-Host
+func setForwarded(pr *httputil.ProxyRequest) {
+	info, ok := pr.In.Context().Value(forwardedKey{}).(*forwarded)
+	if !ok {
+		return
+	}
+
+	proto := "http"
+
+	if pr.In.TLS != nil {
+		proto = "https"
+	}
+
+	nodes := make([]string, 0, len(info.hops))
+	elements := make([]string, 0, len(info.hops))
+
+	for i, h := range info.hops {
+		nodes = append(nodes, strings.Trim(h.node, "[]"))
+
+		params := append([]string{"for=" + forwardedNode(h.node)}, h.params...)
+
+		// Последний узел - отправитель запроса балансировщику
+		if i == len(info.hops)-1 {
+			params = append(params, "host="+quoteForwarded(pr.In.Host), "proto="+proto)
+		}
+
+		elements = append(elements, strings.Join(params, ";"))
+	}
+
+	pr.Out.Header.Set("X-Forwarded-For", strings.Join(nodes, ", "))
+	pr.Out.Header.Set("X-Forwarded-Host", info.host)
+	pr.Out.Header.Set("X-Forwarded-Proto", info.proto)
+	pr.Out.Header.Set("Forwarded", strings.Join(elements, ", "))
+}
+
+// Разбирает элементы заголовков Forwarded (RFC 7239). Значения в кавычках
+// могут содержать запятые и точки с запятой, поэтому они не разделяются
+func parseForwarded(values []string) []hop {
+	var hops []hop
+
+	for _, value := range values {
+		for _, element := range splitForwarded(value, ',') {
+			var h hop
+
+			for _, pair := range splitForwarded(element, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+
+				if strings.EqualFold(name, "for") {
+					h.node = unquoteForwarded(value)
+					continue
+				}
+
+				h.params = append(h.params, name+"="+value)
+
+				switch {
+				case strings.EqualFold(name, "host"):
+					h.host = unquoteForwarded(value)
+				case strings.EqualFold(name, "proto"):
+					// Принимаются только схемы, которые поддерживает балансировщик
+					proto := strings.ToLower(unquoteForwarded(value))
+
+					if proto == "http" || proto == "https" {
+						h.proto = proto
+					}
+				}
+			}
+
+			if h.node != "" {
+				hops = append(hops, h)
+			}
+		}
+	}
+
+	return hops
+}
+
+// Разделяет значение заголовка Forwarded по символу sep вне кавычек
+func splitForwarded(value string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, value[start:])
+}
+
+// Возвращает значение без кавычек и экранирования (quoted-string из RFC 7230)
+func unquoteForwarded(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+
+	var b strings.Builder
+
+	for i := 1; i < len(value)-1; i++ {
+		if value[i] == '\\' && i+1 < len(value)-1 {
+			i++
+		}
+
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
+
+// Разбирает адреса из заголовков X-Forwarded-For
+func parseForwardedFor(values []string) []hop {
+	var hops []hop
+
+	for _, value := range values {
+		for _, node := range strings.Split(value, ",") {
+			node = strings.TrimSpace(node)
+
+			if node != "" {
+				hops = append(hops, hop{node: node})
+			}
+		}
+	}
+
+	return hops
+}
+
+// Форматирует адрес узла для Forwarded: адреса IPv6 и адреса с портом заключаются в кавычки
+func forwardedNode(node string) string {
+	addr, err := netip.ParseAddr(strings.Trim(node, "[]"))
+	if err == nil && addr.Is6() && !addr.Is4In6() {
+		return `"[` + addr.String() + `]"`
+	}
+
+	return quoteForwarded(node)
+}
+
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, `:[]"; ,`) {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+
+	return value
+}
@@ -0,0 +1,121 @@
+package balancer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Коды статусов gRPC, которые возвращает балансировщик
+const (
+	grpcUnknown           = 2
+	grpcDeadlineExceeded  = 4
+	grpcResourceExhausted = 8
+	grpcInternal          = 13
+	grpcUnavailable       = 14
+	grpcUnauthenticated   = 16
+)
+
+// Ключ контекста для запросов, пришедших на маршрут gRPC
+type grpcKey struct{}
+
+// Отмечает запрос как gRPC и устанавливает дедлайн из заголовка grpc-timeout
+func startGRPC(r *http.Request) (*http.Request, context.CancelFunc) {
+	ctx := context.WithValue(r.Context(), grpcKey{}, true)
+
+	timeout, ok := parseGRPCTimeout(r.Header.Get("Grpc-Timeout"))
+	if !ok {
+		return r.WithContext(ctx), func() {}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	return r.WithContext(ctx), cancel
+}
+
+// Проверяет, что запрос пришёл на маршрут gRPC
+func isGRPC(r *http.Request) bool {
+	grpc, _ := r.Context().Value(grpcKey{}).(bool)
+	return grpc
+}
+
+// Отправляет ошибку в формате ответа, который ожидает клиент маршрута
+func responseErrorFor(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if isGRPC(r) {
+		ResponseGRPCError(w, message, code)
+		return
+	}
+
+	responseMessage(w, ResponseMessage{
+		Code:      code,
+		Message:   message,
+		RequestID: requestID(r.Context()),
+	})
+}
+
+// Возвращает код статуса gRPC для HTTP-статуса ошибки балансировщика
+func grpcStatus(code int) int {
+	switch code {
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	case http.StatusInternalServerError:
+		return grpcInternal
+	default:
+		return grpcUnknown
+	}
+}
+
+// Разбирает значение заголовка grpc-timeout (например, "100m" или "5S")
+func parseGRPCTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 || len(value) > 9 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	unit, ok := units[value[len(value)-1]]
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(n) * unit, true
+}
+
+// Кодирует сообщение для заголовка grpc-message (percent-encoding)
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+
+	for i := range len(message) {
+		c := message[i]
+
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+			continue
+		}
+
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+
+	return b.String()
+}
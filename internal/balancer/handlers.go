@@ -1,62 +1,279 @@
 package balancer
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/accesslog"
 	"github.com/imotkin/http-balancer/internal/client"
+	"github.com/imotkin/http-balancer/internal/limiter"
+	"github.com/imotkin/http-balancer/internal/metrics"
+	"github.com/imotkin/http-balancer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Основной метод для работы балансировщика. Обработчик получает данные ключа клиента из
 // HTTP-заголовка 'X-API-Key' в формате UUID, проверяет наличие свободных запросов для
 // данного клиента и при их наличии выполняет переадресацию исходного HTTP-запроса
-func (b *Balancer) Forward(trackConnections bool) http.Handler {
-	Error := func(w http.ResponseWriter, code int, message string, args ...any) {
-		b.logger.Error(message, append(args, "code", code)...)
-		ResponseError(w, message, code)
+// на один из серверов группы, к которой относится маршрут
+func (b *Balancer) Forward(route *Route) http.Handler {
+	Error := func(w http.ResponseWriter, r *http.Request, code int, message string, args ...any) {
+		b.logger.ErrorContext(r.Context(), message, append(args, "code", code)...)
+
+		responseErrorFor(w, r, message, code)
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Идентификатор запроса передаётся серверу, возвращается клиенту
+		// и добавляется во все записи логов для запроса. Заголовок ответа
+		// задаётся до проксирования, чтобы он был и в ответах с ошибкой
+		// балансировщика, а такой же заголовок из ответа сервера удаляется
+		id := newRequestID(r, b.requestIDHeader)
+
+		r = withRequestID(r, b.requestIDHeader, id)
+		r.Header.Set(b.requestIDHeader, id)
+		w.Header().Set(b.requestIDHeader, id)
+
+		if route.grpc {
+			var cancel context.CancelFunc
+
+			r, cancel = startGRPC(r)
+			defer cancel()
+		}
+
+		info := b.forwardedFor(r)
+		r = withForwarded(r, info)
+
+		entry := &accesslog.Entry{
+			Time:       time.Now(),
+			RequestID:  id,
+			RemoteAddr: info.client,
+			Method:     r.Method,
+			Path:       r.URL.RequestURI(),
+			Proto:      r.Proto,
+			Referer:    r.Referer(),
+			UserAgent:  r.UserAgent(),
+			Route:      route.pattern,
+			Pool:       route.pool.name,
+		}
+
+		// Запись используется для журнала запросов и метрик
+		recorder := accesslog.NewResponseWriter(w)
+		defer b.finishRequest(route, entry, recorder, isUpgrade(r))
+
+		w = recorder
+		r = r.WithContext(accesslog.WithEntry(r.Context(), entry))
+
+		// Спан запроса продолжает трассу клиента из заголовка traceparent
+		ctx := tracing.Propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := b.tracer.Start(ctx, "Forward "+route.pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route.pattern),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", info.client),
+				attribute.String("balancer.request_id", id),
+			),
+		)
+		defer func() {
+			endSpan(span, recorder.Status())
+		}()
+
+		r = r.WithContext(ctx)
+
 		key := r.Header.Get("X-API-Key")
 
-		if key == "" {
-			Error(w, http.StatusUnauthorized, "client key is not found")
-			return
+		// В журнал запросов записывается только открытая часть ключа
+		entry.ClientKey, _, _ = client.ParseKey(key)
+
+		clientID, err := b.clientID(r, key)
+
+		// Потоки и соединения со сменой протокола учитываются
+		// лимитером как один запрос на всё время своей жизни
+		var allowed bool
+
+		if err == nil {
+			allowed, err = b.limiter.Available(r.Context(), clientID)
 		}
 
-		err := uuid.Validate(key)
 		if err != nil {
-			Error(w, http.StatusUnauthorized, "invalid client key")
+			switch {
+			case errors.Is(err, errNoCredentials):
+				Error(w, r, http.StatusUnauthorized, "client key is not found")
+			case errors.Is(err, client.ErrInvalidKey):
+				Error(w, r, http.StatusUnauthorized, "invalid client key")
+			case errors.Is(err, client.ErrRevoked),
+				errors.Is(err, client.ErrKeyMismatch),
+				errors.Is(err, client.ErrExpired),
+				errors.Is(err, client.ErrDisabled),
+				errors.Is(err, client.ErrClientExpired),
+				errors.Is(err, client.ErrClientDisabled),
+				errors.Is(err, limiter.ErrUnknownClient):
+				Error(w, r, http.StatusUnauthorized, err.Error(), "key_id", entry.ClientKey, "client", clientID)
+			default:
+				Error(w, r, http.StatusInternalServerError, "failed to check a client", "key_id", entry.ClientKey, "client", clientID, "err", err)
+			}
+
 			return
 		}
 
-		if !b.limiter.Available(r.Context(), key) {
-			Error(w, http.StatusTooManyRequests, "too many requests", "client", key)
+		entry.ClientName = b.limiter.Name(clientID)
+		entry.Limiter = accesslog.LimiterAllowed
+
+		if !allowed {
+			entry.Limiter = accesslog.LimiterDenied
+		}
+
+		metrics.LimiterDecisions.WithLabelValues(b.clientLabel(entry), entry.Limiter).Inc()
+
+		span.SetAttributes(
+			attribute.String("balancer.client", entry.ClientName),
+			attribute.String("balancer.limiter", entry.Limiter),
+		)
+
+		if !allowed {
+			Error(w, r, http.StatusTooManyRequests, "too many requests", "client", clientID)
 			return
 		}
 
-		b.mu.Lock()
-		endpoint := b.strategy.Next()
-		b.mu.Unlock()
+		b.logger.DebugContext(r.Context(), "request is allowed", "client", clientID)
+
+		_, pick := tracing.Start(r.Context(), "pool.Next",
+			trace.WithAttributes(attribute.String("balancer.pool", route.pool.name)),
+		)
+
+		endpoint := route.pool.Next()
+
+		if endpoint != nil {
+			pick.SetAttributes(attribute.String("server.address", endpoint.url.Host))
+		}
+
+		pick.End()
 
 		if endpoint == nil {
-			Error(w, http.StatusServiceUnavailable, "no available endpoint", "client", key)
+			Error(w, r, http.StatusServiceUnavailable, "no available endpoint", "client", clientID, "pool", route.pool.name)
 			return
 		}
 
-		if trackConnections {
-			endpoint.NewConnection(r.Context())
+		entry.Endpoint = endpoint.url.Host
+
+		proxy := endpoint.proxy
+
+		switch {
+		case isUpgrade(r):
+			if !route.upgrades.Acquire(clientID) {
+				Error(w, r, http.StatusTooManyRequests, "too many upgraded connections", "client", clientID)
+				return
+			}
+			defer route.upgrades.Release(clientID)
+
+			// Соединение после смены протокола живёт дольше обычного запроса,
+			// поэтому вместо общего таймаута используется время простоя
+			w = &upgradeWriter{ResponseWriter: w, idleTimeout: route.upgradeIdleTimeout}
+		case route.stream:
+			var cancel context.CancelFunc
+
+			w, r, cancel = startStream(w, r, route.streamIdleTimeout)
+			defer cancel()
+
+			proxy = endpoint.streamProxy
+		case route.timeout > 0:
+			ctx, cancel := context.WithTimeout(r.Context(), route.timeout)
+			defer cancel()
+
+			r = r.WithContext(ctx)
 		}
 
-		b.logger.Info("Forward request", "client", key, "endpoint", endpoint.id)
+		endpoint.NewConnection()
+		defer endpoint.ReleaseConnection()
 
-		endpoint.proxy.ServeHTTP(w, r)
+		b.logger.InfoContext(r.Context(), "Forward request", "client", clientID, "source", info.client, "pool", route.pool.name, "endpoint", endpoint.id)
+
+		// Контекст спана передаётся серверу в заголовке traceparent
+		ctx, upstream := tracing.Start(r.Context(), "upstream",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("server.address", endpoint.url.Host)),
+		)
+		defer func() {
+			endSpan(upstream, recorder.Status())
+		}()
+
+		entry.StartUpstream()
+
+		proxy.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// Записывает статус ответа в спан и завершает его
+func endSpan(span trace.Span, status int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+
+	span.End()
+}
+
+// Возвращает значение метки client для метрик. Количество значений метки
+// растёт вместе с количеством клиентов, поэтому имя клиента записывается
+// только при включённой настройке admin.metricsClientLabel
+func (b *Balancer) clientLabel(entry *accesslog.Entry) string {
+	if !b.config.Admin.MetricsClientLabel {
+		return ""
+	}
+
+	return entry.ClientName
+}
+
+// Дополняет запись итогами ответа, обновляет метрики и записывает запрос
+// в журнал с учётом доли записей маршрута
+func (b *Balancer) finishRequest(route *Route, entry *accesslog.Entry, w *accesslog.ResponseWriter, upgrade bool) {
+	entry.Status = w.Status()
+	entry.Bytes = w.Bytes()
+	entry.Duration = time.Since(entry.Time)
+
+	// После смены протокола ответ отправляется через перехваченное соединение
+	if entry.Status == 0 && upgrade {
+		entry.Status = http.StatusSwitchingProtocols
+	}
+
+	metrics.Requests.WithLabelValues(
+		route.pattern, strconv.Itoa(entry.Status), entry.Endpoint, b.clientLabel(entry),
+	).Inc()
+
+	metrics.RequestDuration.WithLabelValues(route.pattern).Observe(entry.Duration.Seconds())
+
+	if entry.UpstreamLatency != 0 {
+		metrics.UpstreamDuration.
+			WithLabelValues(route.pattern, entry.Endpoint).
+			Observe(entry.UpstreamLatency.Seconds())
+	}
+
+	if b.accessLog == nil {
+		return
+	}
+
+	if entry.Status < http.StatusInternalServerError && !accesslog.Sampled(route.accessLogSampleRate) {
+		return
+	}
+
+	b.accessLog.Log(entry)
+}
+
 func (b *Balancer) AddClient() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var client client.Client
@@ -73,7 +290,7 @@ func (b *Balancer) AddClient() http.Handler {
 			return
 		}
 
-		key, err := b.clients.Add(r.Context(), client)
+		id, key, err := b.clients.Add(r.Context(), client)
 		if err != nil {
 			b.logger.Error("add client", "err", err)
 			ResponseError(w, "failed to add a client", http.StatusInternalServerError)
@@ -82,28 +299,28 @@ func (b *Balancer) AddClient() http.Handler {
 
 		b.logger.Info(
 			"add client",
-			"key", key,
+			"id", id,
 			"name", client.Name,
 			"capacity", client.Capacity,
 			"rate", client.Rate,
 		)
 
-		Response(w, ResponseKey{Key: key})
+		Response(w, ResponseKey{ID: id, Key: key})
 	})
 }
 
 func (b *Balancer) GetClient() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := r.PathValue("key")
+		id := r.PathValue("id")
 
-		if uuid.Validate(key) != nil {
-			ResponseError(w, "invalid client key", http.StatusBadRequest)
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
 			return
 		}
 
-		client, err := b.clients.Get(r.Context(), key)
+		client, err := b.clients.Get(r.Context(), id)
 		if err != nil {
-			b.logger.Error("get client", "key", key, "err", err)
+			b.logger.Error("get client", "id", id, "err", err)
 
 			if errors.Is(err, sql.ErrNoRows) {
 				ResponseError(w, "client is not found", http.StatusNotFound)
@@ -116,7 +333,59 @@ func (b *Balancer) GetClient() http.Handler {
 
 		b.logger.Info(
 			"get client",
-			"key", key,
+			"id", id,
+			"name", client.Name,
+			"capacity", client.Capacity,
+			"rate", client.Rate,
+		)
+
+		Response(w, client)
+	})
+}
+
+// Изменяет имя, ёмкость и скорость пополнения клиента
+// и сразу применяет их к Token Bucket клиента в лимитере
+func (b *Balancer) UpdateClient() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
+			return
+		}
+
+		var update client.Update
+
+		err := json.NewDecoder(r.Body).Decode(&update)
+		if err != nil {
+			ResponseError(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		err = update.Valid()
+		if err != nil {
+			ResponseError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		client, err := b.clients.Update(r.Context(), id, update)
+		if err != nil {
+			b.logger.Error("update client", "id", id, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "client is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to update a client", http.StatusInternalServerError)
+			return
+		}
+
+		b.limiter.Update(id, client)
+
+		b.logger.Info(
+			"update client",
+			"id", id,
 			"name", client.Name,
 			"capacity", client.Capacity,
 			"rate", client.Rate,
@@ -128,16 +397,16 @@ func (b *Balancer) GetClient() http.Handler {
 
 func (b *Balancer) DeleteClient() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := r.PathValue("key")
+		id := r.PathValue("id")
 
-		if uuid.Validate(key) != nil {
-			ResponseError(w, "invalid client key", http.StatusBadRequest)
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
 			return
 		}
 
-		err := b.clients.Delete(r.Context(), key)
+		err := b.clients.Delete(r.Context(), id)
 		if err != nil {
-			b.logger.Error("delete client", "key", key, "err", err)
+			b.logger.Error("delete client", "id", id, "err", err)
 
 			if errors.Is(err, sql.ErrNoRows) {
 				ResponseError(w, "client is not found", http.StatusNotFound)
@@ -148,23 +417,137 @@ func (b *Balancer) DeleteClient() http.Handler {
 			return
 		}
 
-		b.logger.Info("delete client", "key", key)
+		b.revoke(id)
+
+		b.logger.Info("delete client", "id", id)
 
 		w.WriteHeader(http.StatusOK)
 	})
 }
 
+// Удаляет ведро и проверенные ключи клиента, а также сохранённых клиентов
+// для сертификатов, чтобы ключ удалённого клиента сразу перестал приниматься
+func (b *Balancer) revoke(id string) {
+	b.limiter.Remove(id)
+	b.resetCertificates()
+}
+
+// Минимальная и максимальная задержка перед повторной подпиской на уведомления
+const (
+	revocationRetryMin = time.Second
+	revocationRetryMax = time.Minute
+)
+
+// Удаляет из лимитера клиентов и ключи, удалённые на других балансировщиках.
+// Если подписаться на уведомления не удалось (например, PostgreSQL ещё
+// недоступен), то подписка повторяется с увеличивающейся задержкой
+func (b *Balancer) watchRevocations(ctx context.Context) {
+	delay := revocationRetryMin
+
+	for {
+		err := b.clients.WatchRevocations(ctx, b.revoke, b.limiter.RemoveKey, func() {
+			b.limiter.Reset()
+			b.resetCertificates()
+		})
+		if err == nil {
+			return
+		}
+
+		b.logger.Error("watch client revocations", "err", err, "retry_in", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+
+		delay = min(delay*2, revocationRetryMax)
+	}
+}
+
+// Возвращает страницу списка клиентов. Параметры запроса: limit, cursor, name,
+// minCapacity, maxCapacity, minRate, maxRate, disabled, createdAfter, sort, order
 func (b *Balancer) GetList() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		clients, err := b.clients.List(r.Context())
+		options, err := parseListOptions(r.URL.Query())
+		if err != nil {
+			ResponseError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := b.clients.List(r.Context(), options)
 		if err != nil {
+			if errors.Is(err, client.ErrInvalidCursor) {
+				ResponseError(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
 			b.logger.Error("get clients list", "err", err)
 			ResponseError(w, "failed to get clients", http.StatusInternalServerError)
 			return
 		}
 
-		b.logger.Info("get clients list", "len", len(clients))
+		b.logger.Info("get clients list", "len", len(page.Clients), "total", page.Total)
 
-		Response(w, clients)
+		Response(w, page)
 	})
 }
+
+// Разбирает параметры списка клиентов из строки запроса
+func parseListOptions(query url.Values) (client.ListOptions, error) {
+	options := client.ListOptions{
+		Cursor: query.Get("cursor"),
+		Name:   query.Get("name"),
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+	}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return options, errors.New("invalid limit")
+		}
+
+		options.Limit = limit
+	}
+
+	for name, dest := range map[string]**uint{
+		"minCapacity": &options.MinCapacity,
+		"maxCapacity": &options.MaxCapacity,
+		"minRate":     &options.MinRate,
+		"maxRate":     &options.MaxRate,
+	} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return options, fmt.Errorf("invalid %s", name)
+		}
+
+		value := uint(n)
+		*dest = &value
+	}
+
+	if v := query.Get("disabled"); v != "" {
+		disabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return options, errors.New("invalid disabled")
+		}
+
+		options.Disabled = &disabled
+	}
+
+	if v := query.Get("createdAfter"); v != "" {
+		createdAfter, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return options, errors.New("invalid createdAfter")
+		}
+
+		options.CreatedAfter = &createdAfter
+	}
+
+	return options, options.Valid()
+}
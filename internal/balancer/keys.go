@@ -0,0 +1,284 @@
+package balancer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/client"
+)
+
+// Интервал сохранения времени последнего использования ключей
+const keyUsageInterval = time.Minute
+
+// Интервал проверки ключей, срок действия которых скоро истекает
+const expiringKeysInterval = time.Hour
+
+// Стандартный период до окончания срока действия, в течение
+// которого ключ попадает в список истекающих ключей
+const defaultKeyExpiryWarning = 7 * 24 * time.Hour
+
+// Ключи, срок действия которых скоро истекает, по результатам последней проверки
+type ExpiringKeys struct {
+	CheckedAt time.Time       `json:"checkedAt"`
+	Before    time.Time       `json:"before"`
+	Keys      []client.APIKey `json:"keys"`
+}
+
+// Выпускает новый ключ клиента. Старые ключи продолжают работать,
+// пока не будут отозваны, поэтому ключ можно заменить без простоя
+func (b *Balancer) AddKey() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
+			return
+		}
+
+		var options client.KeyOptions
+
+		// Тело запроса с параметрами ключа необязательно
+		err := json.NewDecoder(r.Body).Decode(&options)
+		if err != nil && !errors.Is(err, io.EOF) {
+			ResponseError(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		err = options.Valid()
+		if err != nil {
+			ResponseError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		created, key, err := b.clients.AddKey(r.Context(), id, options)
+		if err != nil {
+			b.logger.Error("add client key", "id", id, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "client is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to add a key", http.StatusInternalServerError)
+			return
+		}
+
+		b.logger.Info("add client key", "id", id, "key_id", created.ID, "expires_at", created.ExpiresAt)
+
+		Response(w, ResponseAPIKey{APIKey: *created, Key: key})
+	})
+}
+
+func (b *Balancer) GetKeys() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
+			return
+		}
+
+		_, err := b.clients.Get(r.Context(), id)
+		if err != nil {
+			b.logger.Error("get client keys", "id", id, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "client is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to get keys", http.StatusInternalServerError)
+			return
+		}
+
+		keys, err := b.clients.ListKeys(r.Context(), id)
+		if err != nil {
+			b.logger.Error("get client keys", "id", id, "err", err)
+			ResponseError(w, "failed to get keys", http.StatusInternalServerError)
+			return
+		}
+
+		Response(w, keys)
+	})
+}
+
+// Изменяет срок действия ключа или отключает его. Ключ удаляется
+// из лимитера и при следующем запросе проверяется заново
+func (b *Balancer) UpdateKey() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
+			return
+		}
+
+		keyID := r.PathValue("keyId")
+
+		var update client.KeyUpdate
+
+		err := json.NewDecoder(r.Body).Decode(&update)
+		if err != nil {
+			ResponseError(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		err = update.Valid()
+		if err != nil {
+			ResponseError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		updated, err := b.clients.UpdateKey(r.Context(), id, keyID, update)
+		if err != nil {
+			b.logger.Error("update client key", "id", id, "key_id", keyID, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "key is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to update a key", http.StatusInternalServerError)
+			return
+		}
+
+		b.limiter.RemoveKey(keyID)
+
+		b.logger.Info(
+			"update client key",
+			"id", id,
+			"key_id", keyID,
+			"expires_at", updated.ExpiresAt,
+			"disabled", updated.Disabled,
+		)
+
+		Response(w, updated)
+	})
+}
+
+// Отзывает один ключ клиента, остальные ключи и ведро клиента не изменяются
+func (b *Balancer) DeleteKey() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid client id", http.StatusBadRequest)
+			return
+		}
+
+		keyID := r.PathValue("keyId")
+
+		err := b.clients.DeleteKey(r.Context(), id, keyID)
+		if err != nil {
+			b.logger.Error("delete client key", "id", id, "key_id", keyID, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "key is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to delete a key", http.StatusInternalServerError)
+			return
+		}
+
+		b.limiter.RemoveKey(keyID)
+
+		b.logger.Info("delete client key", "id", id, "key_id", keyID)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Периодически сохраняет время последнего использования ключей
+func (b *Balancer) watchKeyUsage(ctx context.Context) {
+	ticker := time.NewTicker(keyUsageInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := b.limiter.FlushUsage(ctx)
+			if err != nil {
+				b.logger.Error("save client key usage", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Возвращает ключи, срок действия которых скоро истекает. Если фоновая
+// проверка ещё не выполнялась, то ключи проверяются при запросе
+func (b *Balancer) GetExpiringKeys() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := b.expiringKeys.Load()
+
+		if report == nil {
+			var err error
+
+			report, err = b.checkExpiringKeys(r.Context())
+			if err != nil {
+				b.logger.Error("check expiring keys", "err", err)
+				ResponseError(w, "failed to check expiring keys", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		Response(w, report)
+	})
+}
+
+// Находит ключи, срок действия которых истекает в течение keyExpiryWarning,
+// и сохраняет их для служебного API
+func (b *Balancer) checkExpiringKeys(ctx context.Context) (*ExpiringKeys, error) {
+	window := b.config.KeyExpiryWarning.Duration
+	if window == 0 {
+		window = defaultKeyExpiryWarning
+	}
+
+	now := time.Now().UTC()
+
+	keys, err := b.clients.ExpiringKeys(ctx, now.Add(window))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ExpiringKeys{
+		CheckedAt: now,
+		Before:    now.Add(window),
+		Keys:      keys,
+	}
+
+	b.expiringKeys.Store(report)
+
+	if len(keys) != 0 {
+		b.logger.Warn("client keys expire soon", "count", len(keys), "before", report.Before)
+	}
+
+	return report, nil
+}
+
+// Периодически проверяет ключи, срок действия которых скоро истекает
+func (b *Balancer) watchExpiringKeys(ctx context.Context) {
+	ticker := time.NewTicker(expiringKeysInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := b.checkExpiringKeys(ctx)
+		if err != nil {
+			b.logger.Error("check expiring keys", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
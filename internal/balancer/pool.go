@@ -0,0 +1,94 @@
+package balancer
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/limiter"
+)
+
+// Группа серверов балансировщика с собственной стратегией выбора
+type Pool struct {
+	name string
+
+	// Выбранный алгоритм для работы группы
+	strategy Strategy
+
+	// Мютекс для выбора следующего сервера стратегией
+	mu sync.Mutex
+
+	endpoints []*Endpoint
+
+	// Версия заголовка PROXY для соединений с серверами (0 - не отправляется)
+	proxyProtocol int
+}
+
+// Функция создания группы серверов на основе переданной конфигурации
+func NewPool(cfg config.Pool, healthInterval time.Duration, logLevel slog.Level) (*Pool, error) {
+	endpoints := make([]*Endpoint, 0, len(cfg.Endpoints))
+
+	for _, u := range cfg.Endpoints {
+		endpoint, err := NewEndpoint(u, cfg, healthInterval, logLevel)
+		if err != nil {
+			return nil, err
+		}
+
+		endpoints = append(endpoints, endpoint)
+	}
+
+	pool := &Pool{
+		name:          cfg.Name,
+		endpoints:     endpoints,
+		proxyProtocol: cfg.ProxyProtocolVersion(),
+	}
+
+	switch cfg.Strategy {
+	case RoundRobinStrategy:
+		pool.strategy = &RoundRobin{endpoints: endpoints}
+	case RandomStrategy:
+		pool.strategy = &Random{endpoints: endpoints}
+	case LeastConnectionsStrategy:
+		pool.strategy = &LeastConnections{endpoints: endpoints}
+	}
+
+	return pool, nil
+}
+
+// Возвращает следующий доступный сервер группы
+func (p *Pool) Next() *Endpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.strategy.Next()
+}
+
+// Маршрут, по которому запросы переадресуются в группу серверов
+type Route struct {
+	// Шаблон пути для http.ServeMux
+	pattern string
+
+	// Маршрут для gRPC: ошибки возвращаются в виде grpc-status
+	grpc bool
+
+	pool *Pool
+
+	// Общий таймаут на выполнение запроса к серверу
+	timeout time.Duration
+
+	// Максимальное время простоя для соединений со сменой протокола
+	upgradeIdleTimeout time.Duration
+
+	// Ограничение одновременных соединений со сменой протокола для клиентов
+	upgrades *limiter.Concurrency
+
+	// Потоковая передача ответов с немедленной отправкой данных
+	stream bool
+
+	// Максимальное время без новых данных в потоке
+	streamIdleTimeout time.Duration
+
+	// Доля успешных запросов, которые записываются в журнал запросов (0 - все)
+	accessLogSampleRate float64
+}
@@ -0,0 +1,29 @@
+package balancer
+
+import (
+	"net"
+
+	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/proxyproto"
+)
+
+// Создаёт обёртку для слушателя, которая принимает заголовок PROXY
+// от доверенных источников (nil, если приём не настроен)
+func NewProxyListener(cfg config.ProxyProtocol) (func(net.Listener) net.Listener, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	trusted, err := proxyproto.ParseTrusted(cfg.TrustedSources)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(listener net.Listener) net.Listener {
+		return &proxyproto.Listener{
+			Listener:      listener,
+			Trusted:       trusted,
+			HeaderTimeout: cfg.HeaderTimeout.Duration,
+		}
+	}, nil
+}
@@ -0,0 +1,89 @@
+package balancer
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Заголовок идентификатора запроса по умолчанию
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// Максимальная длина идентификатора запроса, полученного от клиента
+const maxRequestIDLength = 128
+
+// Ключ контекста для идентификатора запроса
+type requestIDKey struct{}
+
+// Идентификатор запроса и название заголовка, в котором он передаётся
+type requestIDValue struct {
+	header string
+	id     string
+}
+
+// Возвращает идентификатор запроса из контекста (пустая строка, если его нет)
+func requestID(ctx context.Context) string {
+	value, _ := ctx.Value(requestIDKey{}).(requestIDValue)
+	return value.id
+}
+
+// Возвращает заголовок идентификатора запроса из контекста (пустая строка, если его нет)
+func requestIDHeader(ctx context.Context) string {
+	value, _ := ctx.Value(requestIDKey{}).(requestIDValue)
+	return value.header
+}
+
+// Сохраняет идентификатор запроса и название его заголовка в контексте запроса
+func withRequestID(r *http.Request, header, id string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestIDValue{header: header, id: id}))
+}
+
+// Возвращает идентификатор из заголовка запроса, если он допустим, иначе создаёт новый
+func newRequestID(r *http.Request, header string) string {
+	id := r.Header.Get(header)
+
+	if validRequestID(id) {
+		return id
+	}
+
+	return uuid.NewString()
+}
+
+// Проверяет, что идентификатор не пустой, не слишком длинный
+// и состоит только из видимых символов ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := range len(id) {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Обработчик логов, который добавляет идентификатор запроса из контекста в каждую запись
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -4,24 +4,47 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+
+	"github.com/imotkin/http-balancer/internal/admin"
+	"github.com/imotkin/http-balancer/internal/client"
 )
 
 type ResponseMessage struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestId,omitempty"`
 }
 
+// Созданный клиент, ключ возвращается только один раз
 type ResponseKey struct {
+	ID  string `json:"id"`
 	Key string `json:"key"`
 }
 
-func ResponseError(w http.ResponseWriter, message string, code int) {
-	w.WriteHeader(code)
+// Новый ключ клиента, секретная часть возвращается только один раз
+type ResponseAPIKey struct {
+	client.APIKey
+
+	Key string `json:"key"`
+}
+
+// Созданный токен администратора, секрет возвращается только один раз
+type ResponseToken struct {
+	admin.Token
+
+	Secret string `json:"token"`
+}
 
-	response := ResponseMessage{
+func ResponseError(w http.ResponseWriter, message string, code int) {
+	responseMessage(w, ResponseMessage{
 		Code:    code,
 		Message: message,
-	}
+	})
+}
+
+func responseMessage(w http.ResponseWriter, response ResponseMessage) {
+	w.WriteHeader(response.Code)
 
 	err := json.NewEncoder(w).Encode(response)
 	if err != nil {
@@ -35,3 +58,15 @@ func Response(w http.ResponseWriter, body any) {
 		log.Println("Failed to send JSON response:", err)
 	}
 }
+
+// Отправляет ошибку для клиента gRPC в виде ответа без тела (Trailers-Only):
+// HTTP-статус 200, а код и сообщение передаются в grpc-status и grpc-message
+func ResponseGRPCError(w http.ResponseWriter, message string, code int) {
+	header := w.Header()
+
+	header.Set("Content-Type", "application/grpc")
+	header.Set("Grpc-Status", strconv.Itoa(grpcStatus(code)))
+	header.Set("Grpc-Message", encodeGRPCMessage(message))
+
+	w.WriteHeader(http.StatusOK)
+}
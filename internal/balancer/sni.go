@@ -0,0 +1,75 @@
+package balancer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Максимальное время ожидания ClientHello от клиента
+const clientHelloTimeout = 5 * time.Second
+
+// Ошибка, которой прерывается рукопожатие после получения ClientHello
+var errClientHelloRead = errors.New("client hello is read")
+
+// Соединение только для чтения, через которое crypto/tls
+// разбирает ClientHello без отправки ответа клиенту
+type readOnlyConn struct {
+	net.Conn
+
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c readOnlyConn) Write(b []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// Читает ClientHello из соединения без расшифровки и возвращает имя сервера
+// из SNI и прочитанные байты, которые необходимо передать серверу группы
+func readClientHello(conn net.Conn) (string, []byte, error) {
+	var (
+		peeked     bytes.Buffer
+		serverName string
+		found      bool
+	)
+
+	conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	err := tls.Server(readOnlyConn{Conn: conn, reader: io.TeeReader(conn, &peeked)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, found = hello.ServerName, true
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+
+	if !found {
+		return "", nil, err
+	}
+
+	return strings.ToLower(serverName), peeked.Bytes(), nil
+}
+
+// Выбирает группу серверов по имени из SNI: сначала точное совпадение,
+// затем шаблон вида *.example.com, иначе группа по умолчанию
+func (l *TCPListener) poolFor(serverName string) *Pool {
+	if pool, ok := l.sni[serverName]; ok {
+		return pool
+	}
+
+	if _, parent, ok := strings.Cut(serverName, "."); ok {
+		if pool, ok := l.sni["*."+parent]; ok {
+			return pool
+		}
+	}
+
+	return l.pool
+}
@@ -76,7 +76,7 @@ func (lc *LeastConnections) Next() *Endpoint {
 			continue
 		}
 
-		num := endpoint.Connections()
+		num := e.Connections()
 
 		if num < minimal {
 			minimal = num
@@ -0,0 +1,59 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Подготавливает ответ для потоковой передачи (SSE, chunked NDJSON): снимает дедлайны
+// сервера и, если задано время простоя, отменяет запрос при отсутствии новых данных
+func startStream(w http.ResponseWriter, r *http.Request, idleTimeout time.Duration) (http.ResponseWriter, *http.Request, context.CancelFunc) {
+	controller := http.NewResponseController(w)
+
+	// Ошибки означают, что ResponseWriter не поддерживает дедлайны,
+	// в этом случае ограничивать поток нечему
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	if idleTimeout <= 0 {
+		return w, r, func() {}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+
+	stream := &streamWriter{
+		ResponseWriter: w,
+		controller:     controller,
+		timeout:        idleTimeout,
+		timer:          time.AfterFunc(idleTimeout, cancel),
+	}
+
+	return stream, r.WithContext(ctx), func() {
+		stream.timer.Stop()
+		cancel()
+	}
+}
+
+// Обёртка для http.ResponseWriter, которая продлевает
+// время простоя потока при каждой записи данных
+type streamWriter struct {
+	http.ResponseWriter
+
+	controller *http.ResponseController
+	timeout    time.Duration
+	timer      *time.Timer
+}
+
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.timer.Reset(w.timeout)
+
+	// Дедлайн записи не даёт зависшему клиенту удерживать поток
+	w.controller.SetWriteDeadline(time.Now().Add(w.timeout))
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
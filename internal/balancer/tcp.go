@@ -0,0 +1,241 @@
+package balancer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/limiter"
+	"github.com/imotkin/http-balancer/internal/proxyproto"
+)
+
+// Интервал удаления неиспользуемых ограничений для IP-адресов
+const pruneInterval = time.Minute
+
+// Слушатель, который передаёт TCP-соединения на серверы группы без разбора содержимого
+type TCPListener struct {
+	addr string
+
+	// Группа серверов (для режима с SNI - группа для неизвестных имён, может быть nil)
+	pool *Pool
+
+	// Группы серверов по именам из SNI для режима передачи TLS без расшифровки
+	sni map[string]*Pool
+
+	// Максимальное время простоя соединения
+	idleTimeout time.Duration
+
+	// Ограничение частоты новых соединений для IP-адресов (nil - без ограничений)
+	limits *limiter.KeyedBuckets
+
+	// Обёртка для приёма заголовка PROXY (nil - заголовок не принимается)
+	wrap func(net.Listener) net.Listener
+
+	logger *slog.Logger
+}
+
+func NewTCPListener(cfg config.Listener, pools map[string]*Pool, logger *slog.Logger) (*TCPListener, error) {
+	wrap, err := NewProxyListener(cfg.ProxyProtocol)
+	if err != nil {
+		return nil, err
+	}
+
+	listener := &TCPListener{
+		addr:        fmt.Sprintf(":%d", cfg.Port),
+		pool:        pools[cfg.Pool],
+		idleTimeout: cfg.IdleTimeout.Duration,
+		wrap:        wrap,
+		logger:      logger,
+	}
+
+	if cfg.Type == config.ListenerTLSPassthrough {
+		listener.sni = make(map[string]*Pool, len(cfg.SNI))
+
+		for name, pool := range cfg.SNI {
+			listener.sni[strings.ToLower(name)] = pools[pool]
+		}
+	}
+
+	if cfg.RateLimit.Enabled() {
+		listener.limits = limiter.NewKeyedBuckets(cfg.RateLimit.Capacity, cfg.RateLimit.Rate)
+	}
+
+	return listener, nil
+}
+
+// Принимает соединения на адресе слушателя до отмены контекста
+func (l *TCPListener) Listen(ctx context.Context) error {
+	listener, err := net.Listen("tcp", l.addr)
+	if err != nil {
+		return err
+	}
+
+	return l.Serve(ctx, listener)
+}
+
+// Принимает соединения из переданного слушателя до отмены контекста
+func (l *TCPListener) Serve(ctx context.Context, listener net.Listener) error {
+	if l.wrap != nil {
+		listener = l.wrap(listener)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	if l.limits != nil {
+		go func() {
+			ticker := time.NewTicker(pruneInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ticker.C:
+					l.limits.Prune()
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	l.logger.Info("started TCP listener", "addr", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			l.logger.Error("accept TCP connection", "err", err)
+			continue
+		}
+
+		go l.handle(ctx, conn)
+	}
+}
+
+func (l *TCPListener) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	if proxied, ok := conn.(*proxyproto.Conn); ok {
+		_, err := proxied.Header()
+		if err != nil {
+			l.logger.Error("read PROXY header", "addr", l.addr, "err", err)
+			return
+		}
+	}
+
+	source, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		source = conn.RemoteAddr().String()
+	}
+
+	if l.limits != nil && !l.limits.Available(source) {
+		l.logger.Error("too many connections", "source", source, "addr", l.addr)
+		return
+	}
+
+	pool := l.pool
+
+	// Байты ClientHello, прочитанные для выбора группы по SNI
+	var peeked []byte
+
+	if l.sni != nil {
+		var serverName string
+
+		serverName, peeked, err = readClientHello(conn)
+		if err != nil {
+			l.logger.Error("read client hello", "source", source, "err", err)
+			return
+		}
+
+		pool = l.poolFor(serverName)
+
+		if pool == nil {
+			l.logger.Error("no pool for server name", "source", source, "server", serverName)
+			return
+		}
+	}
+
+	endpoint := pool.Next()
+
+	if endpoint == nil {
+		l.logger.Error("no available endpoint", "source", source, "pool", pool.name)
+		return
+	}
+
+	upstream, err := endpoint.Dial(ctx)
+	if err != nil {
+		l.logger.Error("dial endpoint", "endpoint", endpoint.id, "err", err)
+		return
+	}
+	defer upstream.Close()
+
+	// Адрес клиента (в том числе полученный из заголовка PROXY) передаётся серверу
+	if pool.proxyProtocol != 0 {
+		header := proxyproto.HeaderFor(pool.proxyProtocol, conn.RemoteAddr(), conn.LocalAddr())
+
+		_, err = header.WriteTo(upstream)
+		if err != nil {
+			l.logger.Error("write PROXY header", "endpoint", endpoint.id, "err", err)
+			return
+		}
+	}
+
+	if len(peeked) != 0 {
+		_, err = upstream.Write(peeked)
+		if err != nil {
+			l.logger.Error("write client hello", "endpoint", endpoint.id, "err", err)
+			return
+		}
+	}
+
+	endpoint.NewConnection()
+	defer endpoint.ReleaseConnection()
+
+	l.logger.Info("Forward connection", "source", source, "pool", pool.name, "endpoint", endpoint.id)
+
+	pipe(conn, upstream, l.idleTimeout)
+}
+
+// Передаёт данные между соединениями в обоих направлениях до закрытия одного из них
+func pipe(client, upstream net.Conn, idleTimeout time.Duration) {
+	// Через клиентское соединение проходят данные в обоих направлениях,
+	// поэтому его обёртка продлевает дедлайны для обоих соединений
+	if idleTimeout > 0 {
+		client = &idleConn{Conn: client, timeout: idleTimeout, peer: upstream}
+	}
+
+	errc := make(chan error, 2)
+
+	transfer := func(dst, src net.Conn) {
+		_, err := io.Copy(dst, src)
+
+		// Закрытие записи передаёт EOF на другую сторону,
+		// сохраняя возможность получить оставшиеся данные
+		if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+			conn.CloseWrite()
+		}
+
+		errc <- err
+	}
+
+	go transfer(upstream, client)
+	go transfer(client, upstream)
+
+	// Ошибка в одном направлении завершает передачу в обоих
+	if err := <-errc; err != nil {
+		return
+	}
+
+	<-errc
+}
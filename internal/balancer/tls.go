@@ -0,0 +1,128 @@
+package balancer
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/server"
+)
+
+// Стандартный интервал проверки файлов сертификатов
+const defaultReloadInterval = 10 * time.Second
+
+// Создаёт конфигурацию TLS для сервера балансировщика и набор
+// сертификатов, которые выбираются по SNI и перезагружаются с диска
+func NewServerTLS(cfg config.TLS) (*tls.Config, *server.Certificates, error) {
+	pairs := make([]server.KeyPair, 0, len(cfg.Certificates))
+
+	for _, c := range cfg.Certificates {
+		pairs = append(pairs, server.KeyPair{CertFile: c.Cert, KeyFile: c.Key})
+	}
+
+	certificates, err := server.LoadCertificates(pairs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version, err := cfg.Version()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	suites, err := cfg.CipherSuiteIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     version,
+		CipherSuites:   suites,
+		GetCertificate: certificates.GetCertificate,
+	}
+
+	// Сертификат клиента необязателен, так как клиенты
+	// могут аутентифицироваться и с помощью X-API-Key
+	if cfg.ClientCA != "" {
+		pool, err := loadCertPool(cfg.ClientCA)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, certificates, nil
+}
+
+// Загружает корневые сертификаты из файла в формате PEM
+func loadCertPool(path string) (*x509.CertPool, error) {
+	bundle, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("no certificates in CA bundle %s", path)
+	}
+
+	return pool, nil
+}
+
+// Создаёт конфигурацию TLS для соединений с серверами группы: корневые
+// сертификаты, сертификат клиента для mTLS и проверку хешей открытого ключа
+func NewUpstreamTLS(cfg config.UpstreamTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+
+	if cfg.CA != "" {
+		pool, err := loadCertPool(cfg.CA)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(cfg.Pins) != 0 {
+		pins := make(map[string]bool, len(cfg.Pins))
+
+		for _, pin := range cfg.Pins {
+			pins[pin] = true
+		}
+
+		// Проверка выполняется после стандартной проверки цепочки сертификатов
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			leaf := state.PeerCertificates[0]
+			hash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+
+			if !pins[base64.StdEncoding.EncodeToString(hash[:])] {
+				return errors.New("server public key is not pinned")
+			}
+
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/admin"
+)
+
+func (b *Balancer) AddToken() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token admin.Token
+
+		err := json.NewDecoder(r.Body).Decode(&token)
+		if err != nil {
+			ResponseError(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		err = token.Valid()
+		if err != nil {
+			ResponseError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Токен не может выдать права, которых нет у него самого
+		caller := admin.FromContext(r.Context())
+
+		for _, scope := range token.Scopes {
+			if caller == nil || !caller.Allowed(scope) {
+				ResponseError(w, "token has no scope "+scope, http.StatusForbidden)
+				return
+			}
+		}
+
+		created, secret, err := b.tokens.Add(r.Context(), token)
+		if err != nil {
+			b.logger.Error("add admin token", "err", err)
+			ResponseError(w, "failed to add a token", http.StatusInternalServerError)
+			return
+		}
+
+		b.logger.Info("add admin token", "id", created.ID, "name", created.Name, "scopes", created.Scopes)
+
+		Response(w, ResponseToken{Token: *created, Secret: secret})
+	})
+}
+
+func (b *Balancer) GetTokens() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokens, err := b.tokens.List(r.Context())
+		if err != nil {
+			b.logger.Error("get admin tokens", "err", err)
+			ResponseError(w, "failed to get tokens", http.StatusInternalServerError)
+			return
+		}
+
+		Response(w, tokens)
+	})
+}
+
+func (b *Balancer) DeleteToken() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if uuid.Validate(id) != nil {
+			ResponseError(w, "invalid token id", http.StatusBadRequest)
+			return
+		}
+
+		err := b.tokens.Delete(r.Context(), id)
+		if err != nil {
+			b.logger.Error("delete admin token", "id", id, "err", err)
+
+			if errors.Is(err, sql.ErrNoRows) {
+				ResponseError(w, "token is not found", http.StatusNotFound)
+				return
+			}
+
+			ResponseError(w, "failed to delete a token", http.StatusInternalServerError)
+			return
+		}
+
+		b.logger.Info("delete admin token", "id", id)
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Возвращает текущую конфигурацию балансировщика без паролей и токенов
+func (b *Balancer) GetConfig() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg := *b.config
+
+		cfg.Database.Password = ""
+		cfg.Admin.Token = ""
+
+		Response(w, cfg)
+	})
+}
@@ -0,0 +1,82 @@
+package balancer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/imotkin/http-balancer/internal/config"
+)
+
+// Стандартные значения транспорта, совпадающие с http.DefaultTransport
+const (
+	defaultDialTimeout     = 30 * time.Second
+	defaultKeepAlive       = 30 * time.Second
+	defaultIdleConnTimeout = 90 * time.Second
+	defaultMaxIdleConns    = 100
+)
+
+var transportDefaults = config.Transport{
+	DialTimeout:     config.Duration{Duration: defaultDialTimeout},
+	KeepAlive:       config.Duration{Duration: defaultKeepAlive},
+	IdleConnTimeout: config.Duration{Duration: defaultIdleConnTimeout},
+	MaxIdleConns:    defaultMaxIdleConns,
+}
+
+// Создаёт dialer для TCP-соединений с сервером балансировщика
+func NewDialer(cfg config.Transport) *net.Dialer {
+	cfg = cfg.Merge(transportDefaults)
+
+	return &net.Dialer{
+		Timeout:   cfg.DialTimeout.Duration,
+		KeepAlive: cfg.KeepAlive.Duration,
+	}
+}
+
+// Создаёт отдельный HTTP-транспорт для сервера балансировщика
+func NewTransport(cfg config.Transport, protocol string, tlsConfig *tls.Config) *http.Transport {
+	cfg = cfg.Merge(transportDefaults)
+
+	dialer := NewDialer(cfg)
+
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout.Duration,
+		IdleConnTimeout:       cfg.IdleConnTimeout.Duration,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		DisableKeepAlives:     cfg.DisableKeepAlives,
+	}
+
+	switch protocol {
+	case config.ProtocolHTTP1:
+		transport.ForceAttemptHTTP2 = false
+	case config.ProtocolHTTP2:
+		// Без HTTP/1 транспорт использует h2c для http:// (prior knowledge)
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return transport
+}
+
+// Проверяет, что ошибка при запросе к серверу вызвана превышением таймаута
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
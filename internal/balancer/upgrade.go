@@ -0,0 +1,103 @@
+package balancer
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Проверяет, что запрос содержит заголовки для смены
+// протокола соединения (например, для WebSocket)
+func isUpgrade(r *http.Request) bool {
+	if r.Header.Get("Upgrade") == "" {
+		return false
+	}
+
+	for _, value := range r.Header.Values("Connection") {
+		for token := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Обёртка для http.ResponseWriter, которая при перехвате соединения (Hijack)
+// снимает дедлайны сервера и ограничивает время простоя соединения
+type upgradeWriter struct {
+	http.ResponseWriter
+
+	// Максимальное время простоя соединения (0 - без ограничений)
+	idleTimeout time.Duration
+}
+
+func (w *upgradeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Дедлайны из ReadTimeout и WriteTimeout сервера
+	// закрыли бы долгоживущее соединение
+	err = conn.SetDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if w.idleTimeout > 0 {
+		conn = &idleConn{Conn: conn, timeout: w.idleTimeout}
+	}
+
+	return conn, brw, nil
+}
+
+func (w *upgradeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Соединение, которое закрывается при отсутствии
+// данных в обоих направлениях дольше заданного времени
+type idleConn struct {
+	net.Conn
+
+	timeout time.Duration
+
+	// Связанное соединение, дедлайн которого продлевается вместе с основным
+	peer net.Conn
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	c.extend()
+	return c.Conn.Read(b)
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	c.extend()
+	return c.Conn.Write(b)
+}
+
+func (c *idleConn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+
+	return errors.ErrUnsupported
+}
+
+// Продлевает дедлайн для чтения и записи, чтобы активность в одном
+// направлении не закрывала ожидающую операцию в другом
+func (c *idleConn) extend() {
+	deadline := time.Now().Add(c.timeout)
+
+	c.Conn.SetDeadline(deadline)
+
+	if c.peer != nil {
+		c.peer.SetDeadline(deadline)
+	}
+}
@@ -3,11 +3,19 @@ package client
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	_ "modernc.org/sqlite"
 
 	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/metrics"
+	"github.com/imotkin/http-balancer/internal/tracing"
 )
 
 // Проверка структуры на соответствие интерфейса Storage
@@ -18,6 +26,10 @@ var _ Storage = (*DatabaseStorage)(nil)
 type DatabaseStorage struct {
 	conn *sql.DB
 
+	// Драйвер и строка подключения, нужны для уведомлений PostgreSQL
+	driver string
+	path   string
+
 	defaults DefaultParams
 }
 
@@ -28,7 +40,9 @@ func NewStorage(driver, path string, defaultCapacity, defaultRate uint) (*Databa
 	}
 
 	return &DatabaseStorage{
-		conn: conn,
+		conn:   conn,
+		driver: driver,
+		path:   path,
 		defaults: DefaultParams{
 			Capacity: defaultCapacity,
 			Rate:     defaultRate,
@@ -44,90 +58,389 @@ func (s *DatabaseStorage) Connection() *sql.DB {
 	return s.conn
 }
 
-// Получение списка клиентов в базе данных
-func (s *DatabaseStorage) List(ctx context.Context) ([]Client, error) {
-	rows, err := s.conn.QueryContext(ctx,
-		`SELECT api_key, name, capacity, rate 
-		   FROM clients 
-		  ORDER BY api_key`)
+// Начинает запрос к хранилищу: создаёт спан трассировки, а по завершении
+// записывает длительность запроса в метрики
+func startQuery(ctx context.Context, query string) (context.Context, func()) {
+	start := time.Now()
+
+	ctx, span := tracing.Start(ctx, "storage."+query)
+
+	return ctx, func() {
+		span.End()
+		metrics.ObserveQuery(query, start)
+	}
+}
+
+// Столбцы клиента для запросов SELECT и RETURNING
+const clientColumns = "id, name, capacity, rate, certificate, expires_at, disabled, created_at"
+
+// Считывает столбцы clientColumns
+func scanClient(row interface{ Scan(...any) error }) (*Client, error) {
+	var (
+		c           Client
+		certificate sql.NullString
+		expiresAt   sql.NullTime
+		createdAt   sql.NullTime
+	)
+
+	err := row.Scan(&c.ID, &c.Name, &c.Capacity, &c.Rate, &certificate, &expiresAt, &c.Disabled, &createdAt)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var clients []Client
+	c.Certificate = certificate.String
+	c.CreatedAt = createdAt.Time
 
-	for rows.Next() {
-		var c Client
+	if expiresAt.Valid {
+		c.ExpiresAt = &expiresAt.Time
+	}
+
+	return &c, nil
+}
+
+// Столбцы ключа для запросов SELECT
+const keyColumns = "id, client_id, created_at, expires_at, last_used_at, disabled"
+
+// Считывает столбцы keyColumns и дополнительные столбцы dest
+func scanKey(row interface{ Scan(...any) error }, dest ...any) (*APIKey, error) {
+	var (
+		k                   APIKey
+		expiresAt, lastUsed sql.NullTime
+	)
+
+	err := row.Scan(append([]any{&k.ID, &k.ClientID, &k.CreatedAt, &expiresAt, &lastUsed, &k.Disabled}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+
+	if lastUsed.Valid {
+		k.LastUsedAt = &lastUsed.Time
+	}
+
+	return &k, nil
+}
+
+// Получение страницы списка клиентов с фильтрами и сортировкой. Страницы
+// выбираются по курсору (значению поля сортировки и идентификатору последнего
+// клиента), поэтому добавление клиентов не сдвигает следующие страницы
+func (s *DatabaseStorage) List(ctx context.Context, options ListOptions) (*Page, error) {
+	ctx, end := startQuery(ctx, "list")
+	defer end()
+
+	options.defaults()
+
+	filter := filterQuery(options)
+
+	page := &Page{Clients: make([]Client, 0)}
+
+	err := s.conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM clients"+filter.String(), filter.args...).
+		Scan(&page.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	column := sortColumns[options.Sort]
+	order, compare := "ASC", ">"
+
+	if options.Order == OrderDesc {
+		order, compare = "DESC", "<"
+	}
+
+	if options.Cursor != "" {
+		value, id, err := decodeCursor(options)
+		if err != nil {
+			return nil, err
+		}
+
+		filter.where(
+			fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, compare),
+			value, value, id,
+		)
+	}
+
+	// Лишний клиент показывает, что есть следующая страница
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+clientColumns+`
+		  FROM clients`+filter.String()+`
+		 ORDER BY `+column+` `+order+`, id `+order+`
+		 LIMIT `+strconv.Itoa(options.Limit+1), filter.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		err = rows.Scan(&c.Key, &c.Name, &c.Capacity, &c.Rate)
+	for rows.Next() {
+		c, err := scanClient(rows)
 		if err != nil {
-			return clients, err
+			return nil, err
 		}
 
-		clients = append(clients, c)
+		page.Clients = append(page.Clients, *c)
 	}
 
 	if err = rows.Err(); err != nil {
-		return clients, err
+		return nil, err
+	}
+
+	if len(page.Clients) > options.Limit {
+		page.Clients = page.Clients[:options.Limit]
+		page.NextCursor = encodeCursor(options, page.Clients[options.Limit-1])
+	}
+
+	return page, nil
+}
+
+// Добавление нового клиента в базу данных вместе с первым ключом. Возвращает
+// идентификатор клиента и ключ, который больше нигде не хранится в открытом виде
+func (s *DatabaseStorage) Add(ctx context.Context, client Client) (string, string, error) {
+	ctx, end := startQuery(ctx, "add")
+	defer end()
+
+	key := GenerateKey()
+
+	id, _, err := s.insert(ctx, client, key)
+	if err != nil {
+		return "", "", err
 	}
 
-	return clients, nil
+	return id, key, nil
 }
 
-// Добавление нового клиента в базу данных
-func (s *DatabaseStorage) Add(ctx context.Context, client Client) (string, error) {
-	key := uuid.NewString()
+// Добавляет клиента и его ключ в одной транзакции
+func (s *DatabaseStorage) insert(ctx context.Context, client Client, key string) (string, *APIKey, error) {
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer tx.Rollback()
+
+	id := uuid.NewString()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO clients (id, name, capacity, rate, expires_at, disabled, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id, client.Name, client.Capacity, client.Rate, utc(client.ExpiresAt), client.Disabled,
+		time.Now().UTC())
+	if err != nil {
+		return "", nil, err
+	}
 
-	_, err := s.conn.ExecContext(ctx, `
-		INSERT INTO clients
-		VALUES ($1, $2, $3, $4)`, key, client.Name, client.Capacity, client.Rate)
+	k, err := insertKey(ctx, tx, id, key, KeyOptions{})
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return key, nil
+	return id, k, tx.Commit()
 }
 
-// Проверка клиента в базе данных и добавление, если его нет
-func (s *DatabaseStorage) Has(ctx context.Context, key string) (*Client, error) {
-	var c Client
+func insertKey(ctx context.Context, tx *sql.Tx, clientID, key string, options KeyOptions) (*APIKey, error) {
+	hash, err := HashKey(key)
+	if err != nil {
+		return nil, err
+	}
 
-	err := s.conn.QueryRowContext(ctx, `
-		INSERT INTO clients
-		VALUES ($1, $2, $3, $4)
-		    ON CONFLICT (api_key)
-			DO UPDATE 
-		   SET api_key = EXCLUDED.api_key
-	 RETURNING api_key, name, capacity, rate`,
-		key, uuid.NewString(), s.Defaults().Capacity, s.Defaults().Rate).
-		Scan(&c.Key, &c.Name, &c.Capacity, &c.Rate)
+	k := &APIKey{
+		ID:        hash.ID,
+		ClientID:  clientID,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	k.ExpiresAt = utc(options.ExpiresAt)
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO api_keys (id, client_id, key_salt, key_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		k.ID, k.ClientID, hash.Salt, hash.Hash, k.CreatedAt, k.ExpiresAt)
 	if err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	return k, nil
+}
+
+// Приводит необязательное время к UTC для хранения в базе данных
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	u := t.UTC()
+
+	return &u
+}
+
+// Поиск клиента по ключу: по открытой части выбирается ключ, а секретная часть
+// сверяется с его хешем. Для ключей удалённых клиентов и отозванных ключей
+// возвращается ErrRevoked, для неверной секретной части - ErrKeyMismatch, для отключённых ключей и клиентов и ключей с истёкшим
+// сроком действия - ошибки из Active
+func (s *DatabaseStorage) FindByKey(ctx context.Context, key string) (*Client, *APIKey, error) {
+	ctx, end := startQuery(ctx, "find_by_key")
+	defer end()
+
+	id, secret, err := ParseKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hash := KeyHash{ID: id}
+
+	k, err := scanKey(s.conn.QueryRowContext(ctx, `
+		SELECT `+keyColumns+`, key_salt, key_hash
+		  FROM api_keys
+		 WHERE id = $1`, id), &hash.Salt, &hash.Hash)
+
+	switch {
+	case err == nil && hash.Verify(secret):
+		now := time.Now()
+
+		if err := k.Active(now); err != nil {
+			return nil, nil, err
+		}
+
+		c, err := s.Get(ctx, k.ClientID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := c.Active(now); err != nil {
+			return nil, nil, err
+		}
+
+		return c, k, nil
+	case err == nil:
+		// Открытая часть совпала с существующим ключом, такой ключ
+		// нельзя зарегистрировать заново
+		return nil, nil, ErrKeyMismatch
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, nil, err
+	}
+
+	var revoked bool
+
+	err = s.conn.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM revoked_keys WHERE key_id = $1)", id).
+		Scan(&revoked)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if revoked {
+		return nil, nil, ErrRevoked
+	}
+
+	return nil, nil, sql.ErrNoRows
+}
+
+// Проверка клиента в базе данных и добавление, если его нет.
+// Ключи удалённых клиентов не добавляются повторно
+func (s *DatabaseStorage) Has(ctx context.Context, key string) (*Client, *APIKey, error) {
+	ctx, end := startQuery(ctx, "has")
+	defer end()
+
+	c, k, err := s.FindByKey(ctx, key)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return c, k, err
+	}
+
+	c = &Client{
+		Name:     uuid.NewString(),
+		Capacity: s.Defaults().Capacity,
+		Rate:     s.Defaults().Rate,
+	}
+
+	c.ID, k, err = s.insert(ctx, *c, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, k, nil
 }
 
-// Получение клиента из базы данных по заданному ключу
-func (s *DatabaseStorage) Get(ctx context.Context, key string) (*Client, error) {
-	var c Client
+// Получение клиента из базы данных по идентификатору
+func (s *DatabaseStorage) Get(ctx context.Context, id string) (*Client, error) {
+	ctx, end := startQuery(ctx, "get")
+	defer end()
 
-	err := s.conn.QueryRowContext(ctx, `
-		SELECT api_key, name, capacity, rate  
+	return scanClient(s.conn.QueryRowContext(ctx, `
+		SELECT `+clientColumns+`
 		  FROM clients 
-		 WHERE api_key = $1`, key).
-		Scan(&c.Key, &c.Name, &c.Capacity, &c.Rate)
+		 WHERE id = $1`, id))
+}
+
+// Изменение параметров клиента, возвращает клиента с новыми параметрами.
+// В PostgreSQL другие балансировщики уведомляются о любом изменении, чтобы
+// удалить ведро клиента и загрузить новые параметры при следующем запросе
+func (s *DatabaseStorage) Update(ctx context.Context, id string, update Update) (*Client, error) {
+	ctx, end := startQuery(ctx, "update")
+	defer end()
+
+	c, err := scanClient(s.conn.QueryRowContext(ctx, `
+		UPDATE clients
+		   SET name = COALESCE($1, name),
+		       capacity = COALESCE($2, capacity),
+		       rate = COALESCE($3, rate),
+		       expires_at = CASE WHEN $4 THEN $5 ELSE expires_at END,
+		       disabled = COALESCE($6, disabled)
+		 WHERE id = $7
+	 RETURNING `+clientColumns,
+		update.Name, update.Capacity, update.Rate,
+		update.ExpiresAt.Set, utc(update.ExpiresAt.Time), update.Disabled, id))
 	if err != nil {
 		return nil, err
 	}
 
-	return &c, nil
+	err = s.notify(ctx, revokeChannel, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
 }
 
-// Удаление клиента из базы данных по заданному ключу
-func (s *DatabaseStorage) Delete(ctx context.Context, key string) error {
-	res, err := s.conn.ExecContext(ctx, "DELETE FROM clients WHERE api_key = $1", key)
+// Отправляет уведомление другим балансировщикам, только для PostgreSQL
+func (s *DatabaseStorage) notify(ctx context.Context, channel, payload string) error {
+	if s.driver != "postgres" {
+		return nil
+	}
+
+	_, err := s.conn.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+
+	return err
+}
+
+// Удаление клиента из базы данных по идентификатору. Ключи клиента сохраняются
+// как отозванные, а в PostgreSQL об удалении уведомляются другие балансировщики
+func (s *DatabaseStorage) Delete(ctx context.Context, id string) error {
+	ctx, end := startQuery(ctx, "delete")
+	defer end()
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO revoked_keys (key_id, revoked_at)
+		SELECT id, $2
+		  FROM api_keys
+		 WHERE client_id = $1
+		    ON CONFLICT (key_id) DO NOTHING`, id, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	// В SQLite внешние ключи не проверяются, поэтому ключи удаляются явно
+	_, err = tx.ExecContext(ctx, "DELETE FROM api_keys WHERE client_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM clients WHERE id = $1", id)
 	if err != nil {
 		return err
 	}
@@ -141,5 +454,288 @@ func (s *DatabaseStorage) Delete(ctx context.Context, key string) error {
 		return sql.ErrNoRows
 	}
 
-	return err
+	// Уведомление доставляется слушателям после фиксации транзакции
+	if s.driver == "postgres" {
+		_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", revokeChannel, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Выпуск нового ключа для существующего клиента
+func (s *DatabaseStorage) AddKey(ctx context.Context, clientID string, options KeyOptions) (*APIKey, string, error) {
+	ctx, end := startQuery(ctx, "add_key")
+	defer end()
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM clients WHERE id = $1)", clientID).
+		Scan(&exists)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !exists {
+		return nil, "", sql.ErrNoRows
+	}
+
+	key := GenerateKey()
+
+	k, err := insertKey(ctx, tx, clientID, key, options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return k, key, tx.Commit()
+}
+
+// Получение ключей клиента без секретных частей
+func (s *DatabaseStorage) ListKeys(ctx context.Context, clientID string) ([]APIKey, error) {
+	ctx, end := startQuery(ctx, "list_keys")
+	defer end()
+
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		  FROM api_keys
+		 WHERE client_id = $1
+		 ORDER BY created_at, id`, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]APIKey, 0)
+
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, *k)
+	}
+
+	return keys, rows.Err()
+}
+
+// Изменение срока действия или отключение ключа клиента. Другие балансировщики
+// уведомляются так же, как при отзыве ключа, и проверяют ключ заново
+func (s *DatabaseStorage) UpdateKey(ctx context.Context, clientID, keyID string, update KeyUpdate) (*APIKey, error) {
+	ctx, end := startQuery(ctx, "update_key")
+	defer end()
+
+	k, err := scanKey(s.conn.QueryRowContext(ctx, `
+		UPDATE api_keys
+		   SET expires_at = CASE WHEN $1 THEN $2 ELSE expires_at END,
+		       disabled = COALESCE($3, disabled)
+		 WHERE id = $4 AND client_id = $5
+	 RETURNING `+keyColumns,
+		update.ExpiresAt.Set, utc(update.ExpiresAt.Time), update.Disabled, keyID, clientID))
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.notify(ctx, revokeKeyChannel, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Получение активных ключей, срок действия которых истекает до before
+func (s *DatabaseStorage) ExpiringKeys(ctx context.Context, before time.Time) ([]APIKey, error) {
+	ctx, end := startQuery(ctx, "expiring_keys")
+	defer end()
+
+	// Время сравнивается после чтения, так как SQLite хранит его в виде строки
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+keyColumns+`
+		  FROM api_keys
+		 WHERE expires_at IS NOT NULL AND NOT disabled`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]APIKey, 0)
+	now := time.Now()
+
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if k.Active(now) == nil && k.ExpiresAt.Before(before) {
+			keys = append(keys, *k)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(keys, func(a, b APIKey) int {
+		return a.ExpiresAt.Compare(*b.ExpiresAt)
+	})
+
+	return keys, nil
+}
+
+// Отзыв одного ключа клиента. Ключ сохраняется как отозванный,
+// а в PostgreSQL об отзыве уведомляются другие балансировщики
+func (s *DatabaseStorage) DeleteKey(ctx context.Context, clientID, keyID string) error {
+	ctx, end := startQuery(ctx, "delete_key")
+	defer end()
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM api_keys WHERE id = $1 AND client_id = $2", keyID, clientID)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO revoked_keys (key_id, revoked_at)
+		VALUES ($1, $2)
+		    ON CONFLICT (key_id) DO NOTHING`, keyID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	if s.driver == "postgres" {
+		_, err = tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", revokeKeyChannel, keyID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Сохранение времени последнего использования ключей
+func (s *DatabaseStorage) TouchKeys(ctx context.Context, used map[string]time.Time) error {
+	ctx, end := startQuery(ctx, "touch_keys")
+	defer end()
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for id, at := range used {
+		_, err = tx.ExecContext(ctx,
+			"UPDATE api_keys SET last_used_at = $1 WHERE id = $2", at.UTC(), id)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Привязка идентификатора сертификата к клиенту, пустое значение удаляет привязку
+func (s *DatabaseStorage) BindCertificate(ctx context.Context, id, certificate string) error {
+	ctx, end := startQuery(ctx, "bind_certificate")
+	defer end()
+
+	res, err := s.conn.ExecContext(ctx, `
+		UPDATE clients
+		   SET certificate = $1
+		 WHERE id = $2`,
+		sql.NullString{String: certificate, Valid: certificate != ""}, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Поиск клиента по идентификаторам сертификата. Если найдено несколько клиентов,
+// то выбирается клиент с идентификатором, который передан раньше остальных
+func (s *DatabaseStorage) FindByCertificate(ctx context.Context, certificates []string) (*Client, error) {
+	ctx, end := startQuery(ctx, "find_by_certificate")
+	defer end()
+
+	if len(certificates) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	placeholders := make([]string, 0, len(certificates))
+	args := make([]any, 0, len(certificates))
+
+	for i, certificate := range certificates {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+		args = append(args, certificate)
+	}
+
+	rows, err := s.conn.QueryContext(ctx, `
+		SELECT `+clientColumns+`
+		  FROM clients
+		 WHERE certificate IN (`+strings.Join(placeholders, ", ")+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		found    *Client
+		priority = len(certificates)
+	)
+
+	for rows.Next() {
+		c, err := scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if i := slices.Index(certificates, c.Certificate); i < priority {
+			found, priority = c, i
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return found, nil
 }
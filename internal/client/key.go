@@ -0,0 +1,188 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Видимый префикс ключей клиентов
+const KeyPrefix = "hb_live_"
+
+const (
+	// Длина открытой части ключа, по которой ключ ищется в базе данных
+	keyIDLength = 12
+
+	// Длина секретной части ключа (128 бит в base32, как в rand.Text)
+	keySecretLength = 26
+
+	// Длина хеша в шестнадцатеричном виде, который используется как открытая
+	// часть ключей в формате UUID, созданных до хеширования
+	legacyKeyIDLength = 16
+)
+
+// Префикс открытой части ключей в формате UUID
+const legacyKeyPrefix = "legacy_"
+
+// Символы частей ключа (base32), совпадают с символами rand.Text
+const keyAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// Ошибка для ключей, которые не соответствуют формату
+var ErrInvalidKey = errors.New("invalid client key")
+
+// Ошибка для ключей, открытая часть которых совпадает с существующим
+// ключом, а секретная часть не совпадает с его хешем
+var ErrKeyMismatch = errors.New("client key is not valid")
+
+// Ошибки для ключей с истёкшим сроком действия и отключённых ключей
+var (
+	ErrExpired  = errors.New("client key is expired")
+	ErrDisabled = errors.New("client key is disabled")
+)
+
+// Ключ клиента без секретной части. У клиента может быть несколько ключей,
+// например, во время замены старого ключа на новый
+type APIKey struct {
+	// Открытая часть ключа
+	ID       string `json:"id"`
+	ClientID string `json:"clientId"`
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	Disabled   bool       `json:"disabled"`
+}
+
+// Проверяет, что ключ не отключён и срок его действия не истёк
+func (k *APIKey) Active(now time.Time) error {
+	switch {
+	case k.Disabled:
+		return ErrDisabled
+	case k.ExpiresAt != nil && !now.Before(*k.ExpiresAt):
+		return ErrExpired
+	default:
+		return nil
+	}
+}
+
+// Параметры нового ключа клиента
+type KeyOptions struct {
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+func (o *KeyOptions) Valid() error {
+	if o.ExpiresAt != nil && !o.ExpiresAt.After(time.Now()) {
+		return errors.New("expiration time is in the past")
+	}
+
+	return nil
+}
+
+// Изменение ключа клиента, параметры со значением nil не изменяются,
+// а срок действия со значением null сбрасывается
+type KeyUpdate struct {
+	ExpiresAt OptionalTime `json:"expiresAt"`
+	Disabled  *bool        `json:"disabled"`
+}
+
+func (u *KeyUpdate) Valid() error {
+	if !u.ExpiresAt.Set && u.Disabled == nil {
+		return errors.New("empty update")
+	}
+
+	return nil
+}
+
+// Хеш ключа клиента, который хранится в базе данных вместо самого ключа
+type KeyHash struct {
+	// Открытая часть ключа (например, hb_live_3KT9XQ2ALM4P), по ней ключ ищется в базе данных
+	ID string
+
+	// Соль и хеш SHA-256 секретной части в шестнадцатеричном виде
+	Salt string
+	Hash string
+}
+
+// Создаёт новый ключ клиента вида hb_live_<id>_<secret>
+func GenerateKey() string {
+	return KeyPrefix + rand.Text()[:keyIDLength] + "_" + rand.Text()
+}
+
+// Разбирает ключ на открытую и секретную части. Кроме ключей с префиксом
+// принимаются ключи в формате UUID, которые выдавались раньше. У них нет
+// открытой части, поэтому она вычисляется из хеша SHA-256 всего ключа,
+// чтобы в журналы и базу данных не попадали символы самого ключа
+func ParseKey(key string) (id, secret string, err error) {
+	if rest, ok := strings.CutPrefix(key, KeyPrefix); ok {
+		id, secret, ok = strings.Cut(rest, "_")
+
+		if !ok || len(id) != keyIDLength || len(secret) != keySecretLength ||
+			!validKeyPart(id) || !validKeyPart(secret) {
+			return "", "", ErrInvalidKey
+		}
+
+		return KeyPrefix + id, secret, nil
+	}
+
+	if uuid.Validate(key) == nil {
+		sum := sha256.Sum256([]byte(key))
+		return legacyKeyPrefix + hex.EncodeToString(sum[:])[:legacyKeyIDLength], key, nil
+	}
+
+	return "", "", ErrInvalidKey
+}
+
+// Вычисляет хеш ключа со случайной солью
+func HashKey(key string) (KeyHash, error) {
+	id, secret, err := ParseKey(key)
+	if err != nil {
+		return KeyHash{}, err
+	}
+
+	salt := make([]byte, 16)
+
+	_, err = rand.Read(salt)
+	if err != nil {
+		return KeyHash{}, err
+	}
+
+	return KeyHash{
+		ID:   id,
+		Salt: hex.EncodeToString(salt),
+		Hash: hashSecret(salt, secret),
+	}, nil
+}
+
+// Проверяет, что секретная часть ключа соответствует хешу
+func (h KeyHash) Verify(secret string) bool {
+	salt, err := hex.DecodeString(h.Salt)
+	if err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hashSecret(salt, secret)), []byte(h.Hash)) == 1
+}
+
+func hashSecret(salt []byte, secret string) string {
+	hash := sha256.New()
+	hash.Write(salt)
+	hash.Write([]byte(secret))
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validKeyPart(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune(keyAlphabet, c) {
+			return false
+		}
+	}
+
+	return true
+}
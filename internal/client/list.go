@@ -0,0 +1,242 @@
+package client
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Поля для сортировки списка клиентов
+const (
+	SortCreatedAt = "createdAt"
+	SortName      = "name"
+	SortCapacity  = "capacity"
+	SortRate      = "rate"
+)
+
+// Столбцы таблицы clients для полей сортировки
+var sortColumns = map[string]string{
+	SortCreatedAt: "created_at",
+	SortName:      "name",
+	SortCapacity:  "capacity",
+	SortRate:      "rate",
+}
+
+// Порядок сортировки
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Количество клиентов на странице по умолчанию и максимальное количество
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// Ошибка для курсоров, которые не получены из предыдущей страницы того же списка
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Параметры получения списка клиентов. Фильтры со значением nil не применяются
+type ListOptions struct {
+	// Количество клиентов на странице и курсор следующей страницы из предыдущего ответа
+	Limit  int
+	Cursor string
+
+	// Подстрока имени клиента без учёта регистра
+	Name string
+
+	// Диапазоны ёмкости и скорости пополнения включительно
+	MinCapacity *uint
+	MaxCapacity *uint
+	MinRate     *uint
+	MaxRate     *uint
+
+	Disabled     *bool
+	CreatedAfter *time.Time
+
+	// Поле (createdAt, name, capacity, rate) и порядок сортировки (asc, desc)
+	Sort  string
+	Order string
+}
+
+func (o *ListOptions) Valid() error {
+	switch {
+	case o.Limit < 0 || o.Limit > MaxListLimit:
+		return fmt.Errorf("limit must be between 1 and %d", MaxListLimit)
+	case o.Sort != "" && sortColumns[o.Sort] == "":
+		return fmt.Errorf("unknown sort field %q", o.Sort)
+	case o.Order != "" && !slices.Contains([]string{OrderAsc, OrderDesc}, o.Order):
+		return fmt.Errorf("unknown sort order %q", o.Order)
+	case o.MinCapacity != nil && o.MaxCapacity != nil && *o.MinCapacity > *o.MaxCapacity:
+		return errors.New("invalid capacity range")
+	case o.MinRate != nil && o.MaxRate != nil && *o.MinRate > *o.MaxRate:
+		return errors.New("invalid rate range")
+	default:
+		return nil
+	}
+}
+
+// Заполняет значения по умолчанию для пустых параметров
+func (o *ListOptions) defaults() {
+	if o.Limit == 0 {
+		o.Limit = DefaultListLimit
+	}
+
+	if o.Sort == "" {
+		o.Sort = SortCreatedAt
+	}
+
+	if o.Order == "" {
+		o.Order = OrderAsc
+	}
+}
+
+// Страница списка клиентов. Total - количество клиентов
+// по фильтрам без учёта страниц
+type Page struct {
+	Clients    []Client `json:"clients"`
+	Total      int      `json:"total"`
+	NextCursor string   `json:"nextCursor,omitempty"`
+}
+
+// Курсор содержит значение поля сортировки и идентификатор последнего
+// клиента на странице, следующая страница начинается после этой пары
+type cursor struct {
+	Sort  string          `json:"s"`
+	Order string          `json:"o"`
+	Value json.RawMessage `json:"v"`
+	ID    string          `json:"id"`
+}
+
+func encodeCursor(options ListOptions, last Client) string {
+	var value any
+
+	switch options.Sort {
+	case SortName:
+		value = last.Name
+	case SortCapacity:
+		value = last.Capacity
+	case SortRate:
+		value = last.Rate
+	default:
+		value = last.CreatedAt
+	}
+
+	raw, _ := json.Marshal(value)
+
+	data, _ := json.Marshal(cursor{
+		Sort:  options.Sort,
+		Order: options.Order,
+		Value: raw,
+		ID:    last.ID,
+	})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Возвращает значение поля сортировки и идентификатор клиента из курсора
+func decodeCursor(options ListOptions) (any, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(options.Cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	var c cursor
+
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Sort != options.Sort || c.Order != options.Order || c.ID == "" {
+		return nil, "", ErrInvalidCursor
+	}
+
+	var value any
+
+	switch options.Sort {
+	case SortName:
+		var name string
+		err = json.Unmarshal(c.Value, &name)
+		value = name
+	case SortCapacity, SortRate:
+		var n uint
+		err = json.Unmarshal(c.Value, &n)
+		value = n
+	default:
+		var createdAt time.Time
+		err = json.Unmarshal(c.Value, &createdAt)
+		value = createdAt.UTC()
+	}
+
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+
+	return value, c.ID, nil
+}
+
+// Условия WHERE для списка клиентов с нумерацией параметров
+type listQuery struct {
+	conditions []string
+	args       []any
+}
+
+// Добавляет условие, в котором ? заменяется на следующий параметр
+func (q *listQuery) where(condition string, args ...any) {
+	for _, arg := range args {
+		q.args = append(q.args, arg)
+		condition = strings.Replace(condition, "?", fmt.Sprintf("$%d", len(q.args)), 1)
+	}
+
+	q.conditions = append(q.conditions, condition)
+}
+
+func (q *listQuery) String() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// Экранирует символы шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Условия фильтров без учёта курсора, общие для страницы и количества клиентов
+func filterQuery(options ListOptions) listQuery {
+	var q listQuery
+
+	if options.Name != "" {
+		q.where(`LOWER(name) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(options.Name))+"%")
+	}
+
+	if options.MinCapacity != nil {
+		q.where("capacity >= ?", *options.MinCapacity)
+	}
+
+	if options.MaxCapacity != nil {
+		q.where("capacity <= ?", *options.MaxCapacity)
+	}
+
+	if options.MinRate != nil {
+		q.where("rate >= ?", *options.MinRate)
+	}
+
+	if options.MaxRate != nil {
+		q.where("rate <= ?", *options.MaxRate)
+	}
+
+	if options.Disabled != nil {
+		q.where("disabled = ?", *options.Disabled)
+	}
+
+	if options.CreatedAfter != nil {
+		q.where("created_at > ?", options.CreatedAfter.UTC())
+	}
+
+	return q
+}
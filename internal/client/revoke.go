@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Каналы PostgreSQL для уведомлений об удалении клиентов и отзыве отдельных ключей
+const (
+	revokeChannel    = "client_revoked"
+	revokeKeyChannel = "key_revoked"
+)
+
+// Интервал проверки соединения слушателя уведомлений
+const revokePingInterval = 90 * time.Second
+
+// Получает идентификаторы клиентов, удалённых на других балансировщиках, и вызывает для них
+// revoke, а для отозванных ключей вызывает revokeKey. До подписки на каналы и во время
+// переподключения уведомления могли быть потеряны, поэтому после подписки и после каждого
+// переподключения вызывается reset. Уведомления доступны только для PostgreSQL,
+// для SQLite функция сразу завершается
+func (s *DatabaseStorage) WatchRevocations(ctx context.Context, revoke func(id string), revokeKey func(keyID string), reset func()) error {
+	if s.driver != "postgres" {
+		return nil
+	}
+
+	listener := pq.NewListener(s.path, time.Second, time.Minute, nil)
+	defer listener.Close()
+
+	for _, channel := range []string{revokeChannel, revokeKeyChannel} {
+		err := listener.Listen(channel)
+		if err != nil {
+			return fmt.Errorf("listen %s: %w", channel, err)
+		}
+	}
+
+	reset()
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			// Пустое уведомление отправляется после восстановления соединения
+			if n == nil {
+				reset()
+				continue
+			}
+
+			if n.Channel == revokeKeyChannel {
+				revokeKey(n.Extra)
+			} else {
+				revoke(n.Extra)
+			}
+		case <-time.After(revokePingInterval):
+			go listener.Ping()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -2,14 +2,35 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"time"
+)
+
+// Ошибка для отозванных ключей и ключей удалённых клиентов
+var ErrRevoked = errors.New("client key is revoked")
+
+// Ошибки для отключённых клиентов и клиентов с истёкшим сроком действия
+var (
+	ErrClientDisabled = errors.New("client is disabled")
+	ErrClientExpired  = errors.New("client is expired")
 )
 
 type Client struct {
+	ID       string `json:"id,omitempty"`
 	Name     string `json:"name,omitempty"`
-	Key      string `json:"key,omitempty"`
 	Capacity uint   `json:"capacity,omitempty"`
 	Rate     uint   `json:"rate,omitempty"`
+
+	// Идентификатор сертификата клиента (sha256:..., subject:..., san:...)
+	Certificate string `json:"certificate,omitempty"`
+
+	// Срок действия клиента и признак отключения. Параметры отключённого
+	// клиента сохраняются, но запросы с его ключами отклоняются
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Disabled  bool       `json:"disabled"`
+
+	CreatedAt time.Time `json:"createdAt,omitzero"`
 }
 
 func (c *Client) Valid() error {
@@ -20,6 +41,79 @@ func (c *Client) Valid() error {
 		return errors.New("null capacity")
 	case c.Rate == 0:
 		return errors.New("null rate")
+	case c.ExpiresAt != nil && !c.ExpiresAt.After(time.Now()):
+		return errors.New("expiration time is in the past")
+	default:
+		return nil
+	}
+}
+
+// Проверяет, что клиент не отключён и срок его действия не истёк
+func (c *Client) Active(now time.Time) error {
+	switch {
+	case c.Disabled:
+		return ErrClientDisabled
+	case c.ExpiresAt != nil && !now.Before(*c.ExpiresAt):
+		return ErrClientExpired
+	default:
+		return nil
+	}
+}
+
+// Изменение параметров клиента, параметры со значением nil не изменяются
+type Update struct {
+	Name      *string      `json:"name"`
+	Capacity  *uint        `json:"capacity"`
+	Rate      *uint        `json:"rate"`
+	ExpiresAt OptionalTime `json:"expiresAt"`
+	Disabled  *bool        `json:"disabled"`
+}
+
+// Изменяется ли состояние клиента (срок действия или отключение)
+func (u *Update) Status() bool {
+	return u.ExpiresAt.Set || u.Disabled != nil
+}
+
+// Время в изменении, для которого отличается отсутствие поля в JSON
+// (значение не изменяется) и явный null (значение сбрасывается)
+type OptionalTime struct {
+	// Поле передано, в том числе со значением null
+	Set bool
+
+	// Новое значение, nil - сброс значения
+	Time *time.Time
+}
+
+func (o *OptionalTime) UnmarshalJSON(data []byte) error {
+	o.Set = true
+
+	if string(data) == "null" {
+		o.Time = nil
+		return nil
+	}
+
+	var t time.Time
+
+	err := json.Unmarshal(data, &t)
+	if err != nil {
+		return err
+	}
+
+	o.Time = &t
+
+	return nil
+}
+
+func (u *Update) Valid() error {
+	switch {
+	case u.Name == nil && u.Capacity == nil && u.Rate == nil && !u.Status():
+		return errors.New("empty update")
+	case u.Name != nil && *u.Name == "":
+		return errors.New("empty name")
+	case u.Capacity != nil && *u.Capacity == 0:
+		return errors.New("null capacity")
+	case u.Rate != nil && *u.Rate == 0:
+		return errors.New("null rate")
 	default:
 		return nil
 	}
@@ -31,11 +125,26 @@ type DefaultParams struct {
 }
 
 type Storage interface {
-	Add(ctx context.Context, client Client) (string, error)
-	Delete(ctx context.Context, key string) error
-	Has(ctx context.Context, key string) (*Client, error)
-	Get(ctx context.Context, key string) (*Client, error)
-	List(ctx context.Context) ([]Client, error)
+	Add(ctx context.Context, client Client) (id string, key string, err error)
+	Delete(ctx context.Context, id string) error
+	Has(ctx context.Context, key string) (*Client, *APIKey, error)
+	Get(ctx context.Context, id string) (*Client, error)
+	Update(ctx context.Context, id string, update Update) (*Client, error)
+	List(ctx context.Context, options ListOptions) (*Page, error)
+
+	FindByKey(ctx context.Context, key string) (*Client, *APIKey, error)
+
+	AddKey(ctx context.Context, clientID string, options KeyOptions) (*APIKey, string, error)
+	ListKeys(ctx context.Context, clientID string) ([]APIKey, error)
+	UpdateKey(ctx context.Context, clientID, keyID string, update KeyUpdate) (*APIKey, error)
+	DeleteKey(ctx context.Context, clientID, keyID string) error
+	ExpiringKeys(ctx context.Context, before time.Time) ([]APIKey, error)
+	TouchKeys(ctx context.Context, used map[string]time.Time) error
+
+	BindCertificate(ctx context.Context, id, certificate string) error
+	FindByCertificate(ctx context.Context, certificates []string) (*Client, error)
+
+	WatchRevocations(ctx context.Context, revoke func(id string), revokeKey func(keyID string), reset func()) error
 
 	Defaults() DefaultParams
 }
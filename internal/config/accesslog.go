@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"text/template"
+)
+
+// Форматы журнала запросов
+const (
+	AccessLogJSON     = "json"
+	AccessLogCombined = "combined"
+	AccessLogTemplate = "template"
+)
+
+var accessLogFormats = []string{
+	AccessLogJSON,
+	AccessLogCombined,
+	AccessLogTemplate,
+}
+
+// Настройки журнала запросов (access log)
+type AccessLog struct {
+	// Формат записей: json, combined (Apache) или template (пустой - журнал отключён)
+	Format string `json:"format"`
+
+	// Шаблон text/template для формата template, например "{{.Method}} {{.Path}} {{.Status}}"
+	Template string `json:"template"`
+
+	// Путь к файлу журнала (пустой - стандартный вывод)
+	Path string `json:"path"`
+
+	// Максимальный размер файла в мегабайтах до ротации (0 - без ограничения)
+	MaxSize uint `json:"maxSize"`
+
+	// Интервал ротации файла (0 - без ротации по времени)
+	RotateInterval Duration `json:"rotateInterval"`
+
+	// Количество сохраняемых старых файлов (0 - все файлы)
+	MaxBackups uint `json:"maxBackups"`
+}
+
+// Проверяет, что журнал запросов включён
+func (a AccessLog) Enabled() bool {
+	return a.Format != ""
+}
+
+func (a AccessLog) Validate() error {
+	if !a.Enabled() {
+		return nil
+	}
+
+	if !slices.Contains(accessLogFormats, a.Format) {
+		return fmt.Errorf("invalid format %q", a.Format)
+	}
+
+	if a.Format == AccessLogTemplate {
+		if a.Template == "" {
+			return errors.New("empty template")
+		}
+
+		_, err := template.New("access").Parse(a.Template)
+		if err != nil {
+			return fmt.Errorf("invalid template: %w", err)
+		}
+	}
+
+	if a.RotateInterval.Duration < 0 {
+		return errors.New("negative rotate interval")
+	}
+
+	if (a.MaxSize != 0 || a.RotateInterval.Duration != 0) && a.Path == "" {
+		return errors.New("rotation requires a file path")
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Переменная окружения с токеном служебного слушателя, если он не задан в конфигурации
+const AdminTokenEnv = "ADMIN_TOKEN"
+
+// Настройки отдельного слушателя для служебных обработчиков
+// (управление клиентами, метрики и т.д.)
+type Admin struct {
+	// Порт для служебных обработчиков (0 - слушатель отключён)
+	Port uint `json:"port"`
+
+	// Путь к unix-сокету, который используется вместо порта
+	Socket string `json:"socket"`
+
+	// Токен для заголовка Authorization: Bearer <token>
+	Token string `json:"token"`
+
+	// Настройки TLS, при заданном clientCA администратор
+	// может аутентифицироваться сертификатом (mTLS)
+	TLS TLS `json:"tls"`
+
+	// Записывать имя клиента в метку client метрик запросов и решений лимитера.
+	// Количество значений метки растёт вместе с количеством клиентов,
+	// поэтому по умолчанию метка пустая
+	MetricsClientLabel bool `json:"metricsClientLabel"`
+}
+
+// Проверяет, что служебный слушатель включён
+func (a Admin) Enabled() bool {
+	return a.Port != 0 || a.Socket != ""
+}
+
+// Проверяет, что для служебных обработчиков задан способ аутентификации
+func (a Admin) Authenticated() bool {
+	return a.Token != "" || a.TLS.ClientCA != ""
+}
+
+func (c *Config) validateAdmin() error {
+	if !c.Admin.Enabled() {
+		return nil
+	}
+
+	if c.Admin.Port != 0 && c.Admin.Socket != "" {
+		return errors.New("admin port and socket are mutually exclusive")
+	}
+
+	if c.Admin.Port != 0 && (c.Admin.Port == c.Port || c.Admin.Port == c.TLS.RedirectPort) {
+		return errors.New("admin port is the same as server port")
+	}
+
+	if err := c.Admin.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid admin TLS: %w", err)
+	}
+
+	if c.Admin.TLS.RedirectPort != 0 {
+		return errors.New("redirect port is not supported for admin listener")
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 var (
 	flagPath           = flag.String("config", "config.json", "Path for a config file")
 	flagPort           = flag.Uint("port", 8080, "Port for HTTP server")
+	flagAdminPort      = flag.Uint("admin-port", 9090, "Port for admin HTTP server (token is read from ADMIN_TOKEN)")
 	flagHealthInterval = flag.Duration("health-interval", time.Second*5, "Health interval for endpoints")
 	flagRefillInterval = flag.Duration("refill-interval", time.Millisecond*100, "Health interval for endpoints")
 	flagEndpoints      = flag.String("endpoints", "[]", "List of endpoints in JSON format")
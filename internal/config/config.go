@@ -10,6 +10,7 @@ import (
 	"slices"
 	"time"
 
+	"github.com/imotkin/http-balancer/internal/proxyproto"
 	"github.com/joho/godotenv"
 )
 
@@ -32,6 +33,25 @@ var (
 		"local",
 		"remote",
 	}
+
+	unknownClients = []string{
+		"",
+		UnknownClientsReject,
+		UnknownClientsRegister,
+		UnknownClientsAnonymous,
+	}
+)
+
+// Действия для запросов с ключом, которого нет в базе данных
+const (
+	// Запрос отклоняется с ошибкой 401
+	UnknownClientsReject = "reject"
+
+	// Ключ добавляется в базу данных со стандартными параметрами
+	UnknownClientsRegister = "register"
+
+	// К запросу применяется общее ведро со стандартными параметрами
+	UnknownClientsAnonymous = "anonymous"
 )
 
 // Дополнительный тип данных для работы с time.Duration
@@ -74,6 +94,9 @@ func Default() *Config {
 			Rate:     1,
 		},
 		Mode: "local",
+		Admin: Admin{
+			Port: 9090,
+		},
 	}
 }
 
@@ -90,6 +113,41 @@ type Config struct {
 	// Выбранная стратегия для работы балансировщика (round-robin, least-connections, random)
 	Strategy string `json:"strategy"`
 
+	// Общие настройки транспорта для соединений с серверами балансировщика
+	Transport Transport `json:"transport"`
+
+	// Группы серверов балансировщика (если не заданы, то используется список Endpoints)
+	Pools []Pool `json:"pools"`
+
+	// Маршруты для переадресации запросов в группы серверов
+	Routes []Route `json:"routes"`
+
+	// Настройки таймаутов для HTTP-сервера балансировщика
+	Server Server `json:"server"`
+
+	// Настройки TLS для приёма HTTPS-запросов
+	TLS TLS `json:"tls"`
+
+	// Дополнительные слушатели на отдельных портах (например, для TCP)
+	Listeners []Listener `json:"listeners"`
+
+	// Адреса и подсети (CIDR) прокси перед балансировщиком, от которых
+	// принимаются заголовки X-Forwarded-* и Forwarded
+	TrustedProxies []string `json:"trustedProxies"`
+
+	// Заголовок с идентификатором запроса, который принимается от клиента,
+	// передаётся серверам и возвращается в ответе (по умолчанию X-Request-ID)
+	RequestIDHeader string `json:"requestIdHeader"`
+
+	// Настройки журнала запросов
+	AccessLog AccessLog `json:"accessLog"`
+
+	// Настройки служебного слушателя для управления клиентами и метрик
+	Admin Admin `json:"admin"`
+
+	// Настройки распределённой трассировки
+	Tracing Tracing `json:"tracing"`
+
 	// Интервал для проверки (ping) текущего состояния всех серверов балансировщика
 	HealthInterval Duration `json:"healthInterval"`
 
@@ -99,6 +157,14 @@ type Config struct {
 	// Стандартные значения параметров для клиента в Token Bucket
 	Defaults Defaults `json:"defaults"`
 
+	// Действие для неизвестных ключей клиентов (reject, register, anonymous),
+	// по умолчанию register
+	UnknownClients string `json:"unknownClients"`
+
+	// Период до окончания срока действия ключа, в течение которого ключ
+	// попадает в список истекающих ключей (по умолчанию 7 дней)
+	KeyExpiryWarning Duration `json:"keyExpiryWarning"`
+
 	// Режим работы хранилища для клиентов (локальный - local, удалённый - remote)
 	Mode string `json:"mode"`
 
@@ -138,14 +204,46 @@ func (c *Config) Validate() error {
 		return errors.New("null server port")
 	}
 
-	if len(c.Endpoints) == 0 {
-		return errors.New("list of endpoints is empty")
-	}
-
 	if !slices.Contains(strategies, c.Strategy) {
 		return errors.New("invalid balancer strategy")
 	}
 
+	if err := c.validateUpstreams(); err != nil {
+		return err
+	}
+
+	if err := c.Server.Validate(); err != nil {
+		return fmt.Errorf("invalid server: %w", err)
+	}
+
+	if err := c.TLS.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS: %w", err)
+	}
+
+	if c.TLS.RedirectPort == c.Port {
+		return errors.New("redirect port is the same as server port")
+	}
+
+	if err := c.validateAdmin(); err != nil {
+		return err
+	}
+
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+
+	if _, err := proxyproto.ParseTrusted(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
+	if err := c.AccessLog.Validate(); err != nil {
+		return fmt.Errorf("invalid access log: %w", err)
+	}
+
+	if err := c.Tracing.Validate(); err != nil {
+		return fmt.Errorf("invalid tracing: %w", err)
+	}
+
 	if c.HealthInterval.Duration == 0 {
 		return errors.New("null health interval")
 	}
@@ -162,6 +260,14 @@ func (c *Config) Validate() error {
 		return errors.New("null default rate")
 	}
 
+	if !slices.Contains(unknownClients, c.UnknownClients) {
+		return errors.New("invalid unknown clients action")
+	}
+
+	if c.KeyExpiryWarning.Duration < 0 {
+		return errors.New("negative key expiry warning")
+	}
+
 	if !slices.Contains(modes, c.Mode) {
 		return errors.New("invalid balancer mode")
 	}
@@ -222,7 +328,7 @@ func Load() (*Config, error) {
 			return nil, fmt.Errorf("invalid logging level: %v", *flagLoggingLevel)
 		}
 
-		return &Config{
+		cfg := &Config{
 			Port:           *flagPort,
 			Endpoints:      endpoints,
 			HealthInterval: Duration{*flagHealthInterval},
@@ -232,7 +338,12 @@ func Load() (*Config, error) {
 			Strategy:       *flagStrategy,
 			MigrationsPath: *flagMigrationsPath,
 			FilePath:       *flagFilePath,
-		}, nil
+		}
+
+		cfg.Admin.Port = *flagAdminPort
+		cfg.Admin.Token = os.Getenv(AdminTokenEnv)
+
+		return cfg, nil
 	}
 
 	var cfg Config
@@ -262,5 +373,9 @@ func Load() (*Config, error) {
 		cfg.Database.User = os.Getenv("POSTGRES_USER")
 	}
 
+	if cfg.Admin.Token == "" {
+		cfg.Admin.Token = os.Getenv(AdminTokenEnv)
+	}
+
 	return &cfg, err
 }
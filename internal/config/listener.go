@@ -0,0 +1,124 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Типы дополнительных слушателей балансировщика
+const (
+	// Передача TCP-соединений на серверы без разбора содержимого
+	ListenerTCP = "tcp"
+
+	// Передача TLS-соединений без расшифровки с выбором группы по SNI
+	ListenerTLSPassthrough = "tls-passthrough"
+)
+
+var listenerTypes = []string{
+	ListenerTCP,
+	ListenerTLSPassthrough,
+}
+
+// Дополнительный слушатель, который принимает соединения на отдельном порту
+type Listener struct {
+	// Тип слушателя (tcp, tls-passthrough)
+	Type string `json:"type"`
+
+	// Порт для приёма соединений
+	Port uint `json:"port"`
+
+	// Название группы серверов с адресами tcp://host:port. Для tls-passthrough
+	// это необязательная группа для имён, которых нет в SNI
+	Pool string `json:"pool"`
+
+	// Группы серверов по имени сервера из SNI (для tls-passthrough),
+	// поддерживаются шаблоны вида *.example.com
+	SNI map[string]string `json:"sni"`
+
+	// Максимальное время простоя соединения (0 - без ограничений)
+	IdleTimeout Duration `json:"idleTimeout"`
+
+	// Ограничение частоты новых соединений с одного IP-адреса
+	RateLimit RateLimit `json:"rateLimit"`
+
+	// Приём заголовка PROXY от доверенных источников
+	ProxyProtocol ProxyProtocol `json:"proxyProtocol"`
+}
+
+// Параметры Token Bucket: ёмкость и скорость пополнения токенов в секунду
+type RateLimit struct {
+	Capacity uint `json:"capacity"`
+	Rate     uint `json:"rate"`
+}
+
+// Проверяет, что ограничение задано
+func (r RateLimit) Enabled() bool {
+	return r.Capacity != 0
+}
+
+func (c *Config) validateListeners() error {
+	ports := []uint{c.Port, c.TLS.RedirectPort, c.Admin.Port}
+
+	pools := make(map[string]Pool)
+
+	for _, p := range c.UpstreamPools() {
+		pools[p.Name] = p
+	}
+
+	for _, l := range c.Listeners {
+		if !slices.Contains(listenerTypes, l.Type) {
+			return fmt.Errorf("invalid listener type %q", l.Type)
+		}
+
+		if l.Port == 0 {
+			return errors.New("null listener port")
+		}
+
+		if slices.Contains(ports, l.Port) {
+			return fmt.Errorf("duplicate listener port %d", l.Port)
+		}
+
+		if l.IdleTimeout.Duration < 0 {
+			return fmt.Errorf("negative idle timeout for listener %d", l.Port)
+		}
+
+		if l.RateLimit.Enabled() && l.RateLimit.Rate == 0 {
+			return fmt.Errorf("null rate limit for listener %d", l.Port)
+		}
+
+		if err := l.ProxyProtocol.Validate(); err != nil {
+			return fmt.Errorf("invalid PROXY protocol for listener %d: %w", l.Port, err)
+		}
+
+		names := make([]string, 0, len(l.SNI)+1)
+
+		for _, name := range l.SNI {
+			names = append(names, name)
+		}
+
+		switch {
+		case l.Type == ListenerTCP && len(l.SNI) != 0:
+			return fmt.Errorf("SNI requires tls-passthrough for listener %d", l.Port)
+		case l.Type == ListenerTLSPassthrough && len(l.SNI) == 0:
+			return fmt.Errorf("empty SNI for listener %d", l.Port)
+		case l.Type == ListenerTCP || l.Pool != "":
+			names = append(names, l.Pool)
+		}
+
+		for _, name := range names {
+			pool, ok := pools[name]
+			if !ok {
+				return fmt.Errorf("unknown pool %q for listener %d", name, l.Port)
+			}
+
+			if !pool.TCP() {
+				return fmt.Errorf("pool %q for listener %d is not tcp", name, l.Port)
+			}
+		}
+
+		ports = append(ports, l.Port)
+	}
+
+	return nil
+}
@@ -0,0 +1,429 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Название группы серверов, которая создаётся из полей
+// Endpoints, Strategy и Transport основной конфигурации
+const DefaultPool = "default"
+
+// Протоколы для соединений с серверами балансировщика
+const (
+	// HTTP/1.1, а для https:// также HTTP/2 через ALPN
+	ProtocolAuto = ""
+
+	// Только HTTP/1.1
+	ProtocolHTTP1 = "http1"
+
+	// Только HTTP/2, для http:// используется h2c без шифрования (prior knowledge)
+	ProtocolHTTP2 = "h2"
+)
+
+var protocols = []string{
+	ProtocolAuto,
+	ProtocolHTTP1,
+	ProtocolHTTP2,
+}
+
+// Типы маршрутов балансировщика
+const (
+	RouteHTTP = "http"
+	RouteGRPC = "grpc"
+)
+
+var routeTypes = []string{
+	"",
+	RouteHTTP,
+	RouteGRPC,
+}
+
+// Настройки таймаутов для HTTP-сервера балансировщика.
+// Нулевые значения заменяются стандартными значениями сервера
+type Server struct {
+	// Максимальное время чтения всего запроса, включая тело
+	ReadTimeout Duration `json:"readTimeout"`
+
+	// Максимальное время чтения заголовков запроса
+	ReadHeaderTimeout Duration `json:"readHeaderTimeout"`
+
+	// Максимальное время записи ответа
+	WriteTimeout Duration `json:"writeTimeout"`
+
+	// Время ожидания следующего запроса для keep-alive соединений
+	IdleTimeout Duration `json:"idleTimeout"`
+
+	// Приём заголовка PROXY на основном порту
+	ProxyProtocol ProxyProtocol `json:"proxyProtocol"`
+}
+
+// Настройки HTTP-транспорта для соединений с серверами балансировщика.
+// Нулевые значения заменяются стандартными значениями из http.DefaultTransport
+type Transport struct {
+	// Таймаут на установку TCP-соединения с сервером
+	DialTimeout Duration `json:"dialTimeout"`
+
+	// Интервал keep-alive проверок для TCP-соединений
+	KeepAlive Duration `json:"keepAlive"`
+
+	// Таймаут ожидания заголовков ответа от сервера после отправки запроса
+	ResponseHeaderTimeout Duration `json:"responseHeaderTimeout"`
+
+	// Время жизни простаивающего соединения в пуле
+	IdleConnTimeout Duration `json:"idleConnTimeout"`
+
+	// Максимальное количество простаивающих соединений в пуле
+	MaxIdleConns int `json:"maxIdleConns"`
+
+	// Максимальное количество простаивающих соединений для одного сервера
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost"`
+
+	// Максимальное количество соединений для одного сервера (0 - без ограничений)
+	MaxConnsPerHost int `json:"maxConnsPerHost"`
+
+	// Отключение повторного использования соединений
+	DisableKeepAlives bool `json:"disableKeepAlives"`
+}
+
+// Возвращает настройки транспорта, в которых нулевые
+// значения заменены значениями из переданных настроек
+func (t Transport) Merge(defaults Transport) Transport {
+	if t.DialTimeout.Duration == 0 {
+		t.DialTimeout = defaults.DialTimeout
+	}
+
+	if t.KeepAlive.Duration == 0 {
+		t.KeepAlive = defaults.KeepAlive
+	}
+
+	if t.ResponseHeaderTimeout.Duration == 0 {
+		t.ResponseHeaderTimeout = defaults.ResponseHeaderTimeout
+	}
+
+	if t.IdleConnTimeout.Duration == 0 {
+		t.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+
+	if t.MaxIdleConns == 0 {
+		t.MaxIdleConns = defaults.MaxIdleConns
+	}
+
+	if t.MaxIdleConnsPerHost == 0 {
+		t.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+
+	if t.MaxConnsPerHost == 0 {
+		t.MaxConnsPerHost = defaults.MaxConnsPerHost
+	}
+
+	if !t.DisableKeepAlives {
+		t.DisableKeepAlives = defaults.DisableKeepAlives
+	}
+
+	return t
+}
+
+func (t Transport) Validate() error {
+	switch {
+	case t.DialTimeout.Duration < 0:
+		return errors.New("negative dial timeout")
+	case t.KeepAlive.Duration < 0:
+		return errors.New("negative keep-alive interval")
+	case t.ResponseHeaderTimeout.Duration < 0:
+		return errors.New("negative response header timeout")
+	case t.IdleConnTimeout.Duration < 0:
+		return errors.New("negative idle connection timeout")
+	case t.MaxIdleConns < 0, t.MaxIdleConnsPerHost < 0, t.MaxConnsPerHost < 0:
+		return errors.New("negative connections limit")
+	default:
+		return nil
+	}
+}
+
+// Группа серверов балансировщика с собственной стратегией и настройками транспорта
+type Pool struct {
+	// Уникальное название группы, на которое ссылаются маршруты
+	Name string `json:"name"`
+
+	// Список URL-адресов для серверов группы
+	Endpoints []string `json:"endpoints"`
+
+	// Стратегия балансировки для группы, по умолчанию используется общая стратегия
+	Strategy string `json:"strategy"`
+
+	// Настройки транспорта, незаданные значения берутся из общих настроек
+	Transport Transport `json:"transport"`
+
+	// Протокол для соединений с серверами группы (http1, h2). Для отдельного
+	// сервера HTTP/2 без шифрования можно включить с помощью схемы h2c://
+	Protocol string `json:"protocol"`
+
+	// Настройки TLS для серверов группы с адресами https://
+	TLS UpstreamTLS `json:"tls"`
+
+	// Версия заголовка PROXY (v1, v2), который отправляется серверам
+	// группы перед передачей данных. Только для адресов tcp://
+	SendProxyProtocol string `json:"sendProxyProtocol"`
+}
+
+// Маршрут для переадресации запросов в группу серверов
+type Route struct {
+	// Тип маршрута: http (по умолчанию) или grpc
+	Type string `json:"type"`
+
+	// Шаблон пути в формате http.ServeMux, например "/api/". Для маршрутов
+	// gRPC задаётся сервис или метод: "package.Service" или "package.Service/Method"
+	Path string `json:"path"`
+
+	// Название группы серверов для маршрута
+	Pool string `json:"pool"`
+
+	// Общий таймаут на выполнение запроса к серверу (0 - без ограничений).
+	// Не применяется к соединениям со сменой протокола
+	Timeout Duration `json:"timeout"`
+
+	// Настройки для соединений со сменой протокола (WebSocket и т.д.)
+	Upgrade Upgrade `json:"upgrade"`
+
+	// Настройки потоковой передачи ответов (SSE, chunked NDJSON)
+	Stream Stream `json:"stream"`
+
+	// Доля успешных запросов маршрута, которые записываются в журнал запросов,
+	// от 0 до 1 (0 - все запросы). Ошибки записываются всегда
+	AccessLogSampleRate float64 `json:"accessLogSampleRate"`
+}
+
+// Настройки потоковой передачи ответов для маршрута
+type Stream struct {
+	// Включение потокового режима: данные отправляются клиенту сразу,
+	// а таймауты сервера и маршрута не применяются
+	Enabled bool `json:"enabled"`
+
+	// Максимальное время без новых данных в потоке (0 - без ограничений)
+	IdleTimeout Duration `json:"idleTimeout"`
+}
+
+// Возвращает шаблон для http.ServeMux, по которому маршрут принимает запросы
+func (r Route) Pattern() string {
+	if r.Type != RouteGRPC {
+		return r.Path
+	}
+
+	name := strings.Trim(r.Path, "/")
+
+	switch {
+	case name == "":
+		return "POST /"
+	case strings.Contains(name, "/"):
+		return "POST /" + name
+	default:
+		return "POST /" + name + "/"
+	}
+}
+
+// Настройки для соединений со сменой протокола через заголовок Upgrade
+type Upgrade struct {
+	// Максимальное время простоя соединения (0 - без ограничений)
+	IdleTimeout Duration `json:"idleTimeout"`
+
+	// Максимальное количество одновременных соединений
+	// для одного ключа клиента (0 - без ограничений)
+	MaxPerClient int `json:"maxPerClient"`
+}
+
+// Возвращает список групп серверов. Если группы не заданы явно,
+// то создаётся одна группа из списка Endpoints основной конфигурации
+func (c *Config) UpstreamPools() []Pool {
+	pools := c.Pools
+
+	if len(pools) == 0 {
+		pools = []Pool{{
+			Name:      DefaultPool,
+			Endpoints: c.Endpoints,
+		}}
+	}
+
+	result := make([]Pool, 0, len(pools))
+
+	for _, p := range pools {
+		if p.Strategy == "" {
+			p.Strategy = c.Strategy
+		}
+
+		p.Transport = p.Transport.Merge(c.Transport)
+
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// Возвращает список маршрутов. Если маршруты не заданы явно,
+// то все запросы переадресуются в первую группу HTTP-серверов
+func (c *Config) UpstreamRoutes() []Route {
+	if len(c.Routes) != 0 {
+		return c.Routes
+	}
+
+	for _, p := range c.UpstreamPools() {
+		if p.TCP() {
+			continue
+		}
+
+		return []Route{{
+			Path: "/",
+			Pool: p.Name,
+		}}
+	}
+
+	return nil
+}
+
+// Проверяет, что группа состоит из серверов с адресами tcp://
+func (p Pool) TCP() bool {
+	return len(p.Endpoints) != 0 && strings.HasPrefix(p.Endpoints[0], "tcp://")
+}
+
+func (c *Config) validateUpstreams() error {
+	if len(c.Pools) == 0 && len(c.Endpoints) == 0 {
+		return errors.New("list of endpoints is empty")
+	}
+
+	err := c.Transport.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid transport: %w", err)
+	}
+
+	names := make([]string, 0, len(c.Pools))
+	tcp := make([]string, 0)
+
+	for _, p := range c.UpstreamPools() {
+		switch {
+		case p.Name == "":
+			return errors.New("empty pool name")
+		case slices.Contains(names, p.Name):
+			return fmt.Errorf("duplicate pool %q", p.Name)
+		case len(p.Endpoints) == 0:
+			return fmt.Errorf("list of endpoints is empty for pool %q", p.Name)
+		case !slices.Contains(strategies, p.Strategy):
+			return fmt.Errorf("invalid strategy for pool %q", p.Name)
+		case !slices.Contains(protocols, p.Protocol):
+			return fmt.Errorf("invalid protocol for pool %q", p.Name)
+		}
+
+		err := p.Transport.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid transport for pool %q: %w", p.Name, err)
+		}
+
+		err = p.TLS.Validate()
+		if err != nil {
+			return fmt.Errorf("invalid TLS for pool %q: %w", p.Name, err)
+		}
+
+		err = p.validateProxyProtocol()
+		if err != nil {
+			return fmt.Errorf("invalid pool %q: %w", p.Name, err)
+		}
+
+		for _, endpoint := range p.Endpoints {
+			if strings.HasPrefix(endpoint, "tcp://") != p.TCP() {
+				return fmt.Errorf("pool %q mixes tcp:// and HTTP endpoints", p.Name)
+			}
+		}
+
+		if p.TCP() {
+			tcp = append(tcp, p.Name)
+		}
+
+		names = append(names, p.Name)
+	}
+
+	paths := make([]string, 0, len(c.Routes))
+
+	// Шаблоны маршрутов проверяются в отдельном http.ServeMux, чтобы
+	// неверный шаблон или конфликт шаблонов не вызвал панику при запуске
+	mux := http.NewServeMux()
+
+	for _, r := range c.UpstreamRoutes() {
+		switch {
+		case !slices.Contains(routeTypes, r.Type):
+			return fmt.Errorf("invalid type for route %q", r.Path)
+		case r.Path == "" && r.Type != RouteGRPC:
+			return errors.New("empty route path")
+		case !strings.HasPrefix(patternPath(r.Pattern()), "/"):
+			return fmt.Errorf("route path %q must start with /", r.Path)
+		case slices.Contains(paths, r.Pattern()):
+			return fmt.Errorf("duplicate route %q", r.Path)
+		case !slices.Contains(names, r.Pool):
+			return fmt.Errorf("unknown pool %q for route %q", r.Pool, r.Path)
+		case slices.Contains(tcp, r.Pool):
+			return fmt.Errorf("route %q uses tcp pool %q", r.Path, r.Pool)
+		case r.Timeout.Duration < 0:
+			return fmt.Errorf("negative timeout for route %q", r.Path)
+		case r.Upgrade.IdleTimeout.Duration < 0:
+			return fmt.Errorf("negative upgrade idle timeout for route %q", r.Path)
+		case r.Upgrade.MaxPerClient < 0:
+			return fmt.Errorf("negative upgrade limit for route %q", r.Path)
+		case r.Stream.IdleTimeout.Duration < 0:
+			return fmt.Errorf("negative stream idle timeout for route %q", r.Path)
+		case r.AccessLogSampleRate < 0 || r.AccessLogSampleRate > 1:
+			return fmt.Errorf("invalid access log sample rate for route %q", r.Path)
+		}
+
+		err := registerPattern(mux, r.Pattern())
+		if err != nil {
+			return fmt.Errorf("invalid route %q: %w", r.Path, err)
+		}
+
+		paths = append(paths, r.Pattern())
+	}
+
+	return nil
+}
+
+// Возвращает путь шаблона без метода ("GET /api/" - "/api/")
+func patternPath(pattern string) string {
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return strings.TrimLeft(path, " \t")
+	}
+
+	return pattern
+}
+
+// Добавляет шаблон в http.ServeMux и возвращает ошибку вместо паники
+func registerPattern(mux *http.ServeMux, pattern string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	mux.Handle(pattern, http.NotFoundHandler())
+
+	return nil
+}
+
+func (s Server) Validate() error {
+	switch {
+	case s.ReadTimeout.Duration < 0:
+		return errors.New("negative read timeout")
+	case s.ReadHeaderTimeout.Duration < 0:
+		return errors.New("negative read header timeout")
+	case s.WriteTimeout.Duration < 0:
+		return errors.New("negative write timeout")
+	case s.IdleTimeout.Duration < 0:
+		return errors.New("negative idle timeout")
+	}
+
+	err := s.ProxyProtocol.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid PROXY protocol: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/imotkin/http-balancer/internal/proxyproto"
+)
+
+// Версии протокола PROXY для отправки на серверы группы
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+var proxyProtocolVersions = []string{
+	"",
+	ProxyProtocolV1,
+	ProxyProtocolV2,
+}
+
+// Приём заголовка PROXY (v1 и v2) от балансировщиков нагрузки перед сервисом
+type ProxyProtocol struct {
+	// Адреса и подсети (CIDR), от которых принимается заголовок PROXY.
+	// Соединения от остальных источников обрабатываются без заголовка
+	TrustedSources []string `json:"trustedSources"`
+
+	// Максимальное время ожидания заголовка (по умолчанию 5s)
+	HeaderTimeout Duration `json:"headerTimeout"`
+}
+
+// Проверяет, что приём заголовка включён
+func (p ProxyProtocol) Enabled() bool {
+	return len(p.TrustedSources) != 0
+}
+
+func (p ProxyProtocol) Validate() error {
+	if p.HeaderTimeout.Duration < 0 {
+		return errors.New("negative header timeout")
+	}
+
+	_, err := proxyproto.ParseTrusted(p.TrustedSources)
+
+	return err
+}
+
+// Возвращает номер версии протокола PROXY для отправки на серверы (0 - не отправляется)
+func (p Pool) ProxyProtocolVersion() int {
+	switch p.SendProxyProtocol {
+	case ProxyProtocolV1:
+		return proxyproto.V1
+	case ProxyProtocolV2:
+		return proxyproto.V2
+	default:
+		return 0
+	}
+}
+
+func (p Pool) validateProxyProtocol() error {
+	if !slices.Contains(proxyProtocolVersions, p.SendProxyProtocol) {
+		return fmt.Errorf("invalid PROXY protocol version %q", p.SendProxyProtocol)
+	}
+
+	if p.SendProxyProtocol != "" && !p.TCP() {
+		return errors.New("PROXY protocol requires tcp endpoints")
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Настройки TLS для приёма HTTPS-запросов балансировщиком
+type TLS struct {
+	// Пары сертификатов и ключей, сертификат для соединения выбирается по SNI
+	Certificates []Certificate `json:"certificates"`
+
+	// Минимальная версия TLS (1.0, 1.1, 1.2, 1.3), по умолчанию 1.2
+	MinVersion string `json:"minVersion"`
+
+	// Список разрешённых наборов шифров для TLS 1.0-1.2 (названия из crypto/tls)
+	CipherSuites []string `json:"cipherSuites"`
+
+	// Интервал проверки файлов сертификатов для перезагрузки без перезапуска
+	ReloadInterval Duration `json:"reloadInterval"`
+
+	// Порт для HTTP-сервера, который перенаправляет запросы на HTTPS (0 - отключён)
+	RedirectPort uint `json:"redirectPort"`
+
+	// Путь к файлу с корневыми сертификатами (CA) для проверки сертификатов
+	// клиентов. Если задан, то клиенты могут аутентифицироваться сертификатом
+	ClientCA string `json:"clientCA"`
+}
+
+// Пути к файлам сертификата и закрытого ключа в формате PEM
+type Certificate struct {
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+}
+
+// Проверяет, что приём HTTPS-запросов включён
+func (t *TLS) Enabled() bool {
+	return len(t.Certificates) != 0
+}
+
+// Возвращает минимальную версию TLS в виде константы crypto/tls
+func (t *TLS) Version() (uint16, error) {
+	if t.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	version, ok := tlsVersions[t.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", t.MinVersion)
+	}
+
+	return version, nil
+}
+
+// Возвращает идентификаторы наборов шифров по их названиям
+func (t *TLS) CipherSuiteIDs() ([]uint16, error) {
+	if len(t.CipherSuites) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(t.CipherSuites))
+
+	for _, name := range t.CipherSuites {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func (t *TLS) Validate() error {
+	for _, c := range t.Certificates {
+		if c.Cert == "" || c.Key == "" {
+			return errors.New("empty certificate or key path")
+		}
+	}
+
+	if _, err := t.Version(); err != nil {
+		return err
+	}
+
+	if _, err := t.CipherSuiteIDs(); err != nil {
+		return err
+	}
+
+	if t.ReloadInterval.Duration < 0 {
+		return errors.New("negative reload interval")
+	}
+
+	if t.RedirectPort != 0 && !t.Enabled() {
+		return errors.New("redirect port requires certificates")
+	}
+
+	if t.ClientCA != "" && !t.Enabled() {
+		return errors.New("client CA requires certificates")
+	}
+
+	return nil
+}
+
+// Настройки TLS для соединений с серверами группы
+type UpstreamTLS struct {
+	// Путь к файлу с корневыми сертификатами (CA) для проверки серверов,
+	// по умолчанию используются системные сертификаты
+	CA string `json:"ca"`
+
+	// Пути к сертификату и ключу клиента для взаимной аутентификации (mTLS)
+	Cert string `json:"cert"`
+	Key  string `json:"key"`
+
+	// Имя сервера для SNI и проверки сертификата вместо хоста из URL
+	ServerName string `json:"serverName"`
+
+	// Список допустимых SHA-256 хешей открытого ключа (SPKI) сервера в base64
+	Pins []string `json:"pins"`
+}
+
+func (t *UpstreamTLS) Validate() error {
+	if (t.Cert == "") != (t.Key == "") {
+		return errors.New("client certificate and key must be set together")
+	}
+
+	for _, pin := range t.Pins {
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("invalid pin %q", pin)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Экспортёры трассировки
+const (
+	TracingNone     = "none"
+	TracingOTLPHTTP = "otlp-http"
+	TracingOTLPGRPC = "otlp-grpc"
+	TracingStdout   = "stdout"
+)
+
+var tracingExporters = []string{
+	"",
+	TracingNone,
+	TracingOTLPHTTP,
+	TracingOTLPGRPC,
+	TracingStdout,
+}
+
+// Настройки распределённой трассировки (OpenTelemetry)
+type Tracing struct {
+	// Экспортёр спанов: otlp-http, otlp-grpc, stdout или none (по умолчанию)
+	Exporter string `json:"exporter"`
+
+	// Адрес коллектора для OTLP, например "localhost:4318"
+	// (по умолчанию используются переменные окружения OTEL_EXPORTER_OTLP_*)
+	Endpoint string `json:"endpoint"`
+
+	// Соединение с коллектором без TLS
+	Insecure bool `json:"insecure"`
+
+	// Название сервиса в спанах (по умолчанию http-balancer)
+	ServiceName string `json:"serviceName"`
+
+	// Доля трассируемых запросов от 0 до 1 (0 - все запросы). Если у входящего
+	// запроса есть родительский спан, то используется его решение
+	SampleRate float64 `json:"sampleRate"`
+}
+
+// Проверяет, что трассировка включена
+func (t Tracing) Enabled() bool {
+	return t.Exporter != "" && t.Exporter != TracingNone
+}
+
+func (t Tracing) Validate() error {
+	if !slices.Contains(tracingExporters, t.Exporter) {
+		return fmt.Errorf("invalid exporter %q", t.Exporter)
+	}
+
+	if t.SampleRate < 0 || t.SampleRate > 1 {
+		return errors.New("invalid sample rate")
+	}
+
+	return nil
+}
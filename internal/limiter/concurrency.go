@@ -0,0 +1,54 @@
+package limiter
+
+import "sync"
+
+// Ограничение количества одновременных соединений для каждого ключа
+type Concurrency struct {
+	// Максимальное количество соединений для ключа (0 - без ограничений)
+	limit int
+
+	counts map[string]int
+	mu     sync.Mutex
+}
+
+func NewConcurrency(limit int) *Concurrency {
+	return &Concurrency{
+		limit:  limit,
+		counts: make(map[string]int),
+	}
+}
+
+// Занимает соединение для ключа, если лимит ещё не исчерпан
+func (c *Concurrency) Acquire(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.limit > 0 && c.counts[key] >= c.limit {
+		return false
+	}
+
+	c.counts[key]++
+
+	return true
+}
+
+// Освобождает ранее занятое для ключа соединение
+func (c *Concurrency) Release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts[key] <= 1 {
+		delete(c.counts, key)
+		return
+	}
+
+	c.counts[key]--
+}
+
+// Возвращает текущее количество соединений для ключа
+func (c *Concurrency) Count(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.counts[key]
+}
@@ -2,10 +2,21 @@ package limiter
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/imotkin/http-balancer/internal/client"
+	"github.com/imotkin/http-balancer/internal/config"
+	"github.com/imotkin/http-balancer/internal/metrics"
+	"github.com/imotkin/http-balancer/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/singleflight"
 )
 
 type TokenBucket struct {
@@ -14,6 +25,12 @@ type TokenBucket struct {
 	rate       uint
 	lastRefill time.Time
 	mu         sync.Mutex
+
+	// Имя клиента, которому принадлежит ведро
+	name string
+
+	// Срок действия клиента, нулевое значение - без ограничения
+	expires time.Time
 }
 
 func (b *TokenBucket) Available() bool {
@@ -39,6 +56,20 @@ func (b *TokenBucket) refill(now time.Time) {
 	}
 }
 
+// Изменяет параметры ведра, текущее количество токенов
+// не может быть больше новой ёмкости
+func (b *TokenBucket) Update(capacity, rate uint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Токены за прошедшее время начисляются со старой скоростью
+	b.refill(time.Now())
+
+	b.capacity = capacity
+	b.rate = rate
+	b.tokens = min(b.tokens, capacity)
+}
+
 func NewBucket(capacity, rate uint) *TokenBucket {
 	bucket := &TokenBucket{
 		capacity: capacity,
@@ -49,44 +80,447 @@ func NewBucket(capacity, rate uint) *TokenBucket {
 	return bucket
 }
 
+// Идентификатор и имя клиента для общего ведра неизвестных ключей
+const (
+	AnonymousID   = "anonymous"
+	AnonymousName = "anonymous"
+)
+
+// Ошибка для неизвестных ключей, если они отклоняются
+var ErrUnknownClient = errors.New("unknown client key")
+
+// Проверенный ключ клиента. Вместо ключа в памяти хранится его хеш,
+// чтобы повторные запросы не требовали обращения к базе данных
+type keyEntry struct {
+	client string
+	digest [sha256.Size]byte
+
+	// Срок действия ключа, нулевое значение - без ограничения
+	expires time.Time
+
+	// Время последнего использования ключа (Unix в наносекундах)
+	// и время, которое уже сохранено в базе данных
+	used  atomic.Int64
+	saved int64
+}
+
 type Limiter struct {
+	// Ведра по идентификаторам клиентов
 	buckets map[string]*TokenBucket
+
+	// Проверенные ключи по их открытой части
+	keys map[string]*keyEntry
+
 	// clients Storage
 	clients client.DatabaseStorage
 	mu      sync.RWMutex
+
+	// Действие для неизвестных ключей (reject, register, anonymous)
+	unknownClients string
+
+	// Общее ведро для неизвестных ключей в режиме anonymous
+	anonymous *TokenBucket
+
+	// Объединяет одновременные запросы к базе данных для одного ключа или клиента
+	lookups singleflight.Group
+
+	// Недавно отклонённые ключи и ключи, к которым применяется общее ведро,
+	// по их открытой части
+	unverified map[string]unverifiedKey
+
+	// Увеличивается при удалении ведер и ключей, чтобы результат проверки,
+	// начатой до удаления, не был сохранён после него
+	generation uint64
 }
 
-func New(storage client.DatabaseStorage) *Limiter {
+// Время, в течение которого отклонённый или неизвестный ключ
+// не проверяется в базе данных повторно
+const unverifiedTTL = 10 * time.Second
+
+// Максимальное количество отклонённых и неизвестных ключей в памяти, при
+// превышении список очищается, чтобы перебор случайных ключей не занимал память
+const maxUnverified = 10000
+
+// Отклонённый ключ и ошибка, которая возвращается для него.
+// Для ключей, к которым применяется общее ведро, ошибка равна nil
+type unverifiedKey struct {
+	digest [sha256.Size]byte
+	err    error
+	until  time.Time
+}
+
+// Результат проверки ключа в базе данных
+type lookup struct {
+	client *client.Client
+	key    *client.APIKey
+}
+
+func New(storage client.DatabaseStorage, unknownClients string) *Limiter {
+	defaults := storage.Defaults()
+
+	anonymous := NewBucket(defaults.Capacity, defaults.Rate)
+	anonymous.name = AnonymousName
+
 	return &Limiter{
-		buckets: make(map[string]*TokenBucket),
-		clients: storage,
+		buckets:        make(map[string]*TokenBucket),
+		keys:           make(map[string]*keyEntry),
+		unverified:     make(map[string]unverifiedKey),
+		clients:        storage,
+		unknownClients: unknownClients,
+		anonymous:      anonymous,
 	}
 }
 
-func (l *Limiter) Available(ctx context.Context, key string) bool {
+// Проверяет ключ клиента и возвращает идентификатор клиента. Ключ ищется
+// в базе данных, только если он ещё не проверялся. Для неизвестных ключей
+// в режиме anonymous возвращается AnonymousID
+func (l *Limiter) Authenticate(ctx context.Context, key string) (string, error) {
+	ctx, span := tracing.Start(ctx, "limiter.Authenticate")
+	defer span.End()
+
+	keyID, _, err := client.ParseKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	now := time.Now()
+
 	l.mu.RLock()
-	bucket, found := l.buckets[key]
+	entry, found := l.keys[keyID]
+	unverified, isUnverified := l.unverified[keyID]
+	generation := l.generation
 	l.mu.RUnlock()
 
-	if !found {
-		l.mu.Lock()
-		defer l.mu.Unlock()
+	span.SetAttributes(attribute.Bool("limiter.cached", found))
 
-		if bucket, found = l.buckets[key]; !found {
-			client, err := l.clients.Has(ctx, key)
-			if err != nil {
-				return false
-			}
+	if found && subtle.ConstantTimeCompare(entry.digest[:], digest[:]) == 1 {
+		if !entry.expires.IsZero() && !now.Before(entry.expires) {
+			l.RemoveKey(keyID)
+			return "", client.ErrExpired
+		}
+
+		entry.used.Store(now.UnixNano())
+
+		return entry.client, nil
+	}
+
+	if isUnverified && unverified.digest == digest && now.Before(unverified.until) {
+		if unverified.err == nil {
+			span.SetAttributes(attribute.Bool("limiter.anonymous", true))
+			return AnonymousID, nil
+		}
+
+		span.SetAttributes(attribute.Bool("limiter.rejected", true))
+
+		return "", unverified.err
+	}
+
+	// Запрос к базе данных выполняется без блокировки, а одновременные проверки
+	// одного ключа объединяются. Секретная часть входит в ключ группы, чтобы
+	// ключи с одной открытой частью не получали чужой результат. Отмена одного
+	// запроса не должна прерывать проверку для остальных
+	result, err, _ := l.lookups.Do(keyID+":"+hex.EncodeToString(digest[:]), func() (any, error) {
+		c, k, err := l.find(context.WithoutCancel(ctx), key)
+		return lookup{client: c, key: k}, err
+	})
+	if err != nil {
+		span.RecordError(err)
 
-			created := NewBucket(client.Capacity, client.Rate)
-			l.buckets[key] = created
-			return created.Available()
+		if cacheRejected(err) {
+			l.remember(keyID, digest, err, now)
+		}
+
+		return "", err
+	}
+
+	c, k := result.(lookup).client, result.(lookup).key
+
+	// Ведра для неизвестных ключей не создаются, а сами ключи запоминаются
+	// на короткое время, чтобы запросы со случайными ключами не доходили
+	// до базы данных и ограничивались только общим ведром
+	if c == nil {
+		span.SetAttributes(attribute.Bool("limiter.anonymous", true))
+		l.remember(keyID, digest, nil, now)
+
+		return AnonymousID, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Клиент или ключ удалён во время проверки, результат используется
+	// только для текущего запроса
+	if l.generation != generation {
+		return c.ID, nil
+	}
+
+	entry = &keyEntry{client: c.ID, digest: digest}
+	entry.used.Store(now.UnixNano())
+
+	if k.ExpiresAt != nil {
+		entry.expires = *k.ExpiresAt
+	}
+
+	l.keys[keyID] = entry
+	delete(l.unverified, keyID)
+
+	if _, ok := l.buckets[c.ID]; !ok {
+		l.addBucket(c)
+	}
+
+	return c.ID, nil
+}
+
+// Ошибки проверки ключа, которые не изменятся в ближайшее время
+func cacheRejected(err error) bool {
+	return errors.Is(err, ErrUnknownClient) ||
+		errors.Is(err, client.ErrKeyMismatch) ||
+		errors.Is(err, client.ErrRevoked)
+}
+
+// Сохраняет отклонённый (err не равна nil) или неизвестный ключ
+func (l *Limiter) remember(keyID string, digest [sha256.Size]byte, err error, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.unverified) >= maxUnverified {
+		clear(l.unverified)
+	}
+
+	l.unverified[keyID] = unverifiedKey{digest: digest, err: err, until: now.Add(unverifiedTTL)}
+}
+
+// Проверяет наличие токенов у клиента. Если ведра клиента нет в памяти,
+// то параметры клиента загружаются из хранилища
+func (l *Limiter) Available(ctx context.Context, id string) (allowed bool, err error) {
+	ctx, span := tracing.Start(ctx, "limiter.Available")
+	defer func() {
+		span.SetAttributes(attribute.Bool("limiter.allowed", allowed))
+		span.End()
+	}()
+
+	if id == AnonymousID {
+		return l.anonymous.Available(), nil
+	}
+
+	l.mu.RLock()
+	bucket, found := l.buckets[id]
+	expired := found && !bucket.expires.IsZero() && !time.Now().Before(bucket.expires)
+	generation := l.generation
+	l.mu.RUnlock()
+
+	span.SetAttributes(attribute.Bool("limiter.cached", found))
+
+	// Ведро клиента с истёкшим сроком действия удаляется вместе с его ключами
+	if expired {
+		l.Remove(id)
+		span.RecordError(client.ErrClientExpired)
+		return false, client.ErrClientExpired
+	}
+
+	if found {
+		return bucket.Available(), nil
+	}
+
+	// Параметры клиента загружаются без блокировки так же, как ключи
+	// в Authenticate, а одновременные загрузки одного клиента объединяются
+	result, err, _ := l.lookups.Do("client:"+id, func() (any, error) {
+		return l.clients.Get(context.WithoutCancel(ctx), id)
+	})
+
+	// Клиент удалён после проверки ключа или сертификата
+	if errors.Is(err, sql.ErrNoRows) {
+		err = client.ErrRevoked
+	}
+
+	if err == nil {
+		err = result.(*client.Client).Active(time.Now())
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		return false, err
+	}
+
+	c := result.(*client.Client)
+
+	l.mu.Lock()
+
+	if bucket, found = l.buckets[id]; !found {
+		// Клиент удалён во время загрузки, ведро используется
+		// только для текущего запроса
+		if l.generation != generation {
+			bucket = NewBucket(c.Capacity, c.Rate)
 		} else {
-			return bucket.Available()
+			bucket = l.addBucket(c)
 		}
 	}
 
-	return bucket.Available()
+	l.mu.Unlock()
+
+	return bucket.Available(), nil
+}
+
+// Создаёт ведро клиента, вызывается с блокировкой l.mu
+func (l *Limiter) addBucket(c *client.Client) *TokenBucket {
+	bucket := NewBucket(c.Capacity, c.Rate)
+	bucket.name = c.Name
+
+	if c.ExpiresAt != nil {
+		bucket.expires = *c.ExpiresAt
+	}
+
+	l.buckets[c.ID] = bucket
+
+	metrics.LimiterBuckets.Set(float64(len(l.buckets)))
+
+	return bucket
+}
+
+// Возвращает клиента и ключ с учётом действия для неизвестных ключей.
+// Для ключа, к которому применяется общее ведро, возвращается nil
+func (l *Limiter) find(ctx context.Context, key string) (*client.Client, *client.APIKey, error) {
+	if l.unknownClients == "" || l.unknownClients == config.UnknownClientsRegister {
+		return l.clients.Has(ctx, key)
+	}
+
+	found, k, err := l.clients.FindByKey(ctx, key)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		if l.unknownClients == config.UnknownClientsAnonymous {
+			return nil, nil, nil
+		}
+
+		return nil, nil, ErrUnknownClient
+	}
+
+	return found, k, err
+}
+
+// Удаляет ведро и проверенные ключи клиента, например, после удаления клиента
+func (l *Limiter) Remove(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(id)
+}
+
+// Вызывается с блокировкой l.mu
+func (l *Limiter) remove(id string) {
+	l.generation++
+
+	delete(l.buckets, id)
+
+	for keyID, entry := range l.keys {
+		if entry.client == id {
+			delete(l.keys, keyID)
+		}
+	}
+
+	metrics.LimiterBuckets.Set(float64(len(l.buckets)))
+}
+
+// Удаляет проверенный ключ, ведро клиента остаётся для его других ключей
+func (l *Limiter) RemoveKey(keyID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.generation++
+
+	delete(l.keys, keyID)
+	delete(l.unverified, keyID)
+}
+
+// Сохраняет в базе данных время последнего использования ключей,
+// которые использовались после предыдущего сохранения
+func (l *Limiter) FlushUsage(ctx context.Context) error {
+	used := make(map[string]time.Time)
+
+	l.mu.RLock()
+
+	for keyID, entry := range l.keys {
+		if at := entry.used.Load(); at > entry.saved {
+			used[keyID] = time.Unix(0, at)
+		}
+	}
+
+	l.mu.RUnlock()
+
+	if len(used) == 0 {
+		return nil
+	}
+
+	err := l.clients.TouchKeys(ctx, used)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for keyID, at := range used {
+		if entry, ok := l.keys[keyID]; ok {
+			entry.saved = at.UnixNano()
+		}
+	}
+
+	return nil
+}
+
+// Удаляет все ведра и проверенные ключи, они загружаются из хранилища заново
+func (l *Limiter) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.generation++
+
+	clear(l.buckets)
+	clear(l.keys)
+	clear(l.unverified)
+
+	metrics.LimiterBuckets.Set(0)
+}
+
+// Возвращает имя клиента, если его ведро уже создано
+func (l *Limiter) Name(id string) string {
+	if id == AnonymousID {
+		return AnonymousName
+	}
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if bucket, ok := l.buckets[id]; ok {
+		return bucket.name
+	}
+
+	return ""
+}
+
+// Применяет новые параметры клиента к его ведру, если оно уже создано.
+// Ведро отключённого клиента или клиента с истёкшим сроком действия удаляется
+func (l *Limiter) Update(id string, c *client.Client) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c.Active(time.Now()) != nil {
+		l.remove(id)
+		return
+	}
+
+	bucket, ok := l.buckets[id]
+	if !ok {
+		return
+	}
+
+	bucket.Update(c.Capacity, c.Rate)
+	bucket.name = c.Name
+	bucket.expires = time.Time{}
+
+	if c.ExpiresAt != nil {
+		bucket.expires = *c.ExpiresAt
+	}
 }
 
 func (l *Limiter) StartRefill(ctx context.Context, interval time.Duration) {
@@ -110,3 +544,54 @@ func (l *Limiter) StartRefill(ctx context.Context, interval time.Duration) {
 		}
 	}
 }
+
+// Набор Token Bucket с одинаковыми параметрами для произвольных
+// ключей, например, для ограничения соединений с одного IP-адреса
+type KeyedBuckets struct {
+	capacity uint
+	rate     uint
+	buckets  map[string]*TokenBucket
+	mu       sync.Mutex
+}
+
+func NewKeyedBuckets(capacity, rate uint) *KeyedBuckets {
+	return &KeyedBuckets{
+		capacity: capacity,
+		rate:     rate,
+		buckets:  make(map[string]*TokenBucket),
+	}
+}
+
+func (k *KeyedBuckets) Available(key string) bool {
+	k.mu.Lock()
+
+	bucket, found := k.buckets[key]
+	if !found {
+		bucket = NewBucket(k.capacity, k.rate)
+		k.buckets[key] = bucket
+	}
+
+	k.mu.Unlock()
+
+	return bucket.Available()
+}
+
+// Удаляет полностью заполненные ведра, так как они
+// не отличаются от новых и только занимают память
+func (k *KeyedBuckets) Prune() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+
+	for key, bucket := range k.buckets {
+		bucket.mu.Lock()
+		bucket.refill(now)
+		full := bucket.tokens == bucket.capacity
+		bucket.mu.Unlock()
+
+		if full {
+			delete(k.buckets, key)
+		}
+	}
+}
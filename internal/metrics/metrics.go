@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "balancer"
+
+// Реестр метрик балансировщика, который отдаётся обработчиком Handler
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Количество запросов по маршруту, статусу ответа, серверу и клиенту.
+	// Метка client заполняется только при включённой настройке admin.metricsClientLabel
+	Requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Number of proxied requests.",
+	}, []string{"route", "status", "endpoint", "client"})
+
+	// Общее время обработки запроса балансировщиком
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Total request duration.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// Время от отправки запроса серверу до получения заголовков ответа
+	UpstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_duration_seconds",
+		Help:      "Time to upstream response headers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "endpoint"})
+
+	// Количество активных соединений с сервером
+	InFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "endpoint_in_flight",
+		Help:      "Number of active requests and connections to an endpoint.",
+	}, []string{"pool", "endpoint"})
+
+	// Состояние сервера по результатам проверок здоровья (1 - доступен)
+	EndpointUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "endpoint_up",
+		Help:      "Endpoint health state (1 - active, 0 - inactive).",
+	}, []string{"pool", "endpoint"})
+
+	// Длительность проверок здоровья сервера
+	HealthCheckDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "health_check_duration_seconds",
+		Help:      "Endpoint health check duration.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"pool", "endpoint", "result"})
+
+	// Решения лимитера, метка client заполняется только
+	// при включённой настройке admin.metricsClientLabel
+	LimiterDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "limiter_decisions_total",
+		Help:      "Number of rate limiter decisions.",
+	}, []string{"client", "result"})
+
+	// Количество Token Bucket в памяти лимитера
+	LimiterBuckets = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "limiter_buckets",
+		Help:      "Number of token buckets in the limiter.",
+	})
+
+	// Длительность запросов к хранилищу клиентов
+	StorageQueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_query_duration_seconds",
+		Help:      "Client storage query duration.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Requests,
+		RequestDuration,
+		UpstreamDuration,
+		InFlight,
+		EndpointUp,
+		HealthCheckDuration,
+		LimiterDecisions,
+		LimiterBuckets,
+		StorageQueryDuration,
+	)
+}
+
+// Возвращает обработчик для получения метрик в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Записывает длительность запроса к хранилищу, начатого в момент start
+func ObserveQuery(query string, start time.Time) {
+	StorageQueryDuration.WithLabelValues(query).Observe(time.Since(start).Seconds())
+}
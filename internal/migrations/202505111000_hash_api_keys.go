@@ -0,0 +1,156 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imotkin/http-balancer/internal/client"
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upHashAPIKeys, downHashAPIKeys)
+}
+
+// Заменяет ключи клиентов в открытом виде на соль и хеш. Первичным ключом
+// таблицы clients становится отдельный идентификатор клиента, а ключи
+// удалённых клиентов в revoked_keys заменяются их открытой частью
+func upHashAPIKeys(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `
+		CREATE TABLE clients_hashed (
+			id TEXT PRIMARY KEY,
+			name TEXT UNIQUE NOT NULL,
+			capacity INT NOT NULL,
+			rate INT NOT NULL,
+			certificate TEXT,
+			key_id TEXT NOT NULL,
+			key_salt TEXT NOT NULL,
+			key_hash TEXT NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+
+	type legacyClient struct {
+		key         string
+		name        string
+		capacity    uint
+		rate        uint
+		certificate sql.NullString
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT api_key, name, capacity, rate, certificate FROM clients")
+	if err != nil {
+		return err
+	}
+
+	var clients []legacyClient
+
+	for rows.Next() {
+		var c legacyClient
+
+		err = rows.Scan(&c.key, &c.name, &c.capacity, &c.rate, &c.certificate)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		clients = append(clients, c)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, c := range clients {
+		hash, err := client.HashKey(c.key)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO clients_hashed (id, name, capacity, rate, certificate, key_id, key_salt, key_hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			uuid.NewString(), c.name, c.capacity, c.rate, c.certificate, hash.ID, hash.Salt, hash.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, query := range []string{
+		"DROP INDEX IF EXISTS clients_certificate_idx",
+		"DROP TABLE clients",
+		"ALTER TABLE clients_hashed RENAME TO clients",
+		"CREATE UNIQUE INDEX clients_certificate_idx ON clients (certificate)",
+		"CREATE INDEX clients_key_id_idx ON clients (key_id)",
+		`CREATE TABLE revoked_key_ids (
+			key_id TEXT PRIMARY KEY,
+			revoked_at TIMESTAMP NOT NULL
+		)`,
+	} {
+		_, err = tx.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err = tx.QueryContext(ctx, "SELECT api_key, revoked_at FROM revoked_keys")
+	if err != nil {
+		return err
+	}
+
+	revoked := make(map[string]time.Time)
+
+	for rows.Next() {
+		var (
+			key string
+			at  time.Time
+		)
+
+		err = rows.Scan(&key, &at)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		id, _, err := client.ParseKey(key)
+		if err != nil {
+			continue
+		}
+
+		revoked[id] = at
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for id, at := range revoked {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO revoked_key_ids (key_id, revoked_at) VALUES ($1, $2)", id, at)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "DROP TABLE revoked_keys")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "ALTER TABLE revoked_key_ids RENAME TO revoked_keys")
+
+	return err
+}
+
+// Исходные ключи нельзя восстановить по хешам
+func downHashAPIKeys(ctx context.Context, tx *sql.Tx) error {
+	return errors.New("hashed client keys can't be restored")
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pressly/goose/v3"
+)
+
+func init() {
+	goose.AddMigrationContext(upClientsCreatedAt, downClientsCreatedAt)
+}
+
+// Добавляет время создания клиентов. Существующим клиентам время задаётся
+// через драйвер, а не CURRENT_TIMESTAMP, чтобы в SQLite оно хранилось в том
+// же формате, что и для новых клиентов, и сравнивалось при фильтрации
+func upClientsCreatedAt(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "ALTER TABLE clients ADD COLUMN created_at TIMESTAMP")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE clients SET created_at = $1", time.Now().UTC().Truncate(time.Second))
+
+	return err
+}
+
+func downClientsCreatedAt(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, "ALTER TABLE clients DROP COLUMN created_at")
+
+	return err
+}
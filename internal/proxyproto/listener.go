@@ -0,0 +1,170 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Максимальное время ожидания заголовка PROXY от доверенного источника
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Разбирает адреса и подсети доверенных источников. Отдельный адрес
+// считается подсетью из одного адреса
+func ParseTrusted(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted source %q: %w", value, err)
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted source %q: %w", value, err)
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// Слушатель, который принимает заголовок PROXY от доверенных источников
+// и подменяет адрес клиента в соединении. Для остальных источников
+// соединения передаются без изменений
+type Listener struct {
+	net.Listener
+
+	// Подсети, от которых принимается заголовок PROXY
+	Trusted []netip.Prefix
+
+	// Максимальное время ожидания заголовка (0 - DefaultHeaderTimeout)
+	HeaderTimeout time.Duration
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	timeout := l.HeaderTimeout
+
+	if timeout == 0 {
+		timeout = DefaultHeaderTimeout
+	}
+
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), timeout: timeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	source := addrPort(addr).Addr().Unmap()
+
+	for _, prefix := range l.Trusted {
+		if prefix.Contains(source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Соединение от доверенного источника. Заголовок читается при первом
+// обращении к данным или адресам, чтобы не задерживать приём других соединений
+type Conn struct {
+	net.Conn
+
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	header  *Header
+	err     error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+
+		header, err := Read(c.reader)
+		if errors.Is(err, ErrNoHeader) {
+			// Ошибка ожидания сохраняется в bufio.Reader, поэтому прочитанные
+			// данные переносятся в новый буфер перед чтением соединения
+			buffered, _ := c.reader.Peek(c.reader.Buffered())
+			c.reader = bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), c.Conn))
+			return
+		}
+
+		if err != nil {
+			c.err = fmt.Errorf("read PROXY header: %w", err)
+			return
+		}
+
+		c.header = header
+	})
+}
+
+// Возвращает полученный заголовок (nil, если источник его не отправил)
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// Возвращает адрес клиента из заголовка PROXY или адрес источника соединения
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+
+	if c.header != nil && c.header.HasAddresses() {
+		return net.TCPAddrFromAddrPort(c.header.Source)
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+// Возвращает адрес назначения из заголовка PROXY или локальный адрес соединения
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+
+	if c.header != nil && c.header.HasAddresses() {
+		return net.TCPAddrFromAddrPort(c.header.Destination)
+	}
+
+	return c.Conn.LocalAddr()
+}
+
+// Закрывает запись, если это поддерживает исходное соединение
+func (c *Conn) CloseWrite() error {
+	if conn, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+
+	return errors.ErrUnsupported
+}
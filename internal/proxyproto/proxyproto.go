@@ -0,0 +1,292 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Версии протокола PROXY
+const (
+	V1 = 1
+	V2 = 2
+)
+
+var (
+	// Сигнатура заголовка версии 2
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// Начало заголовка версии 1
+	signatureV1 = []byte("PROXY ")
+
+	ErrNoHeader      = errors.New("no PROXY header")
+	ErrInvalidHeader = errors.New("invalid PROXY header")
+)
+
+// Максимальная длина заголовка версии 1 вместе с CRLF
+const maxHeaderV1 = 107
+
+// Заголовок протокола PROXY с исходными адресами клиента и сервера
+type Header struct {
+	Version int
+
+	// Адрес клиента, от которого получено соединение
+	Source netip.AddrPort
+
+	// Адрес, на который клиент устанавливал соединение
+	Destination netip.AddrPort
+}
+
+// Проверяет, что заголовок содержит адреса клиента (а не LOCAL или UNKNOWN)
+func (h *Header) HasAddresses() bool {
+	return h.Source.IsValid() && h.Destination.IsValid()
+}
+
+// Читает заголовок версии 1 или 2 из начала потока. Если данные не начинаются
+// с сигнатуры протокола, то возвращается ErrNoHeader и данные не считываются
+func Read(r *bufio.Reader) (*Header, error) {
+	// По первому байту определяется версия, чтобы не ожидать
+	// лишних данных от клиентов, которые не отправляют заголовок
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, ErrNoHeader
+	}
+
+	signature := signatureV1
+
+	if first[0] == signatureV2[0] {
+		signature = signatureV2
+	}
+
+	prefix, err := r.Peek(len(signature))
+	if err != nil || !bytes.Equal(prefix, signature) {
+		return nil, ErrNoHeader
+	}
+
+	if first[0] == signatureV2[0] {
+		return readV2(r)
+	}
+
+	return readV1(r)
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+
+	for len(line) < maxHeaderV1 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		line = append(line, b)
+
+		if b == '\n' {
+			break
+		}
+	}
+
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Split(text, " ")
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &Header{Version: V1}, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidHeader
+	}
+
+	source, err := parseAddrPort(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+
+	destination, err := parseAddrPort(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	// Адреса должны относиться к семейству из заголовка
+	if ipv4 := fields[1] == "TCP4"; source.Addr().Is4() != ipv4 || destination.Addr().Is4() != ipv4 {
+		return nil, ErrInvalidHeader
+	}
+
+	return &Header{
+		Version:     V1,
+		Source:      source,
+		Destination: destination,
+	}, nil
+}
+
+func parseAddrPort(addr, port string) (netip.AddrPort, error) {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return netip.AddrPort{}, ErrInvalidHeader
+	}
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return netip.AddrPort{}, ErrInvalidHeader
+	}
+
+	return netip.AddrPortFrom(ip, uint16(n)), nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, len(signatureV2)+4)
+
+	_, err := io.ReadFull(r, fixed)
+	if err != nil {
+		return nil, err
+	}
+
+	command := fixed[12]
+	family := fixed[13]
+	length := binary.BigEndian.Uint16(fixed[14:])
+
+	// Старшие 4 бита - версия, младшие - команда LOCAL (0) или PROXY (1)
+	if command>>4 != V2 || command&0x0F > 0x01 {
+		return nil, ErrInvalidHeader
+	}
+
+	payload := make([]byte, length)
+
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	header := &Header{Version: V2}
+
+	// Команда LOCAL используется для проверок здоровья самим прокси
+	if command&0x0F == 0x00 {
+		return header, nil
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, ErrInvalidHeader
+		}
+
+		header.Source = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[0:4])), binary.BigEndian.Uint16(payload[8:]))
+		header.Destination = netip.AddrPortFrom(netip.AddrFrom4([4]byte(payload[4:8])), binary.BigEndian.Uint16(payload[10:]))
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, ErrInvalidHeader
+		}
+
+		header.Source = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[0:16])), binary.BigEndian.Uint16(payload[32:]))
+		header.Destination = netip.AddrPortFrom(netip.AddrFrom16([16]byte(payload[16:32])), binary.BigEndian.Uint16(payload[34:]))
+	}
+
+	return header, nil
+}
+
+// Записывает заголовок в формате его версии
+func (h *Header) WriteTo(w io.Writer) (int64, error) {
+	var data []byte
+
+	if h.Version == V2 {
+		data = h.formatV2()
+	} else {
+		data = h.formatV1()
+	}
+
+	n, err := w.Write(data)
+
+	return int64(n), err
+}
+
+func (h *Header) formatV1() []byte {
+	if !h.HasAddresses() {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	family := "TCP4"
+
+	if h.Source.Addr().Is6() {
+		family = "TCP6"
+	}
+
+	return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n",
+		family,
+		h.Source.Addr(),
+		h.Destination.Addr(),
+		h.Source.Port(),
+		h.Destination.Port(),
+	)
+}
+
+func (h *Header) formatV2() []byte {
+	data := append([]byte{}, signatureV2...)
+
+	if !h.HasAddresses() {
+		return append(data, 0x20, 0x00, 0x00, 0x00)
+	}
+
+	var family byte = 0x11
+	addresses := append(h.Source.Addr().AsSlice(), h.Destination.Addr().AsSlice()...)
+
+	if h.Source.Addr().Is6() {
+		family = 0x21
+	}
+
+	addresses = binary.BigEndian.AppendUint16(addresses, h.Source.Port())
+	addresses = binary.BigEndian.AppendUint16(addresses, h.Destination.Port())
+
+	data = append(data, 0x21, family)
+	data = binary.BigEndian.AppendUint16(data, uint16(len(addresses)))
+
+	return append(data, addresses...)
+}
+
+// Создаёт заголовок для соединения: клиент - источник, балансировщик - получатель
+func HeaderFor(version int, source, destination net.Addr) *Header {
+	header := &Header{
+		Version:     version,
+		Source:      addrPort(source),
+		Destination: addrPort(destination),
+	}
+
+	// Оба адреса заголовка должны относиться к одному семейству
+	if header.HasAddresses() && header.Source.Addr().Is4() != header.Destination.Addr().Is4() {
+		header.Source = as16(header.Source)
+		header.Destination = as16(header.Destination)
+	}
+
+	return header
+}
+
+func as16(addr netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom16(addr.Addr().As16()), addr.Port())
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	var value netip.AddrPort
+
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		value = tcp.AddrPort()
+	} else {
+		parsed, err := netip.ParseAddrPort(addr.String())
+		if err != nil {
+			return netip.AddrPort{}
+		}
+
+		value = parsed
+	}
+
+	return netip.AddrPortFrom(value.Addr().Unmap(), value.Port())
+}
@@ -0,0 +1,396 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+// Заголовок версии 2 с командой, семейством и адресами
+func headerV2(command, family byte, payload []byte) []byte {
+	data := append([]byte{}, signatureV2...)
+	data = append(data, command, family, byte(len(payload)>>8), byte(len(payload)))
+
+	return append(data, payload...)
+}
+
+func TestRead(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0x30, 0x39, 0x01, 0xBB}
+
+	ipv6 := append(netip.MustParseAddr("2001:db8::1").AsSlice(), netip.MustParseAddr("2001:db8::2").AsSlice()...)
+	ipv6 = append(ipv6, 0x30, 0x39, 0x01, 0xBB)
+
+	tests := []struct {
+		name  string
+		input []byte
+		want  *Header
+		err   error
+	}{
+		{
+			name:  "v1 tcp4",
+			input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\n"),
+			want: &Header{
+				Version:     V1,
+				Source:      netip.MustParseAddrPort("192.0.2.1:12345"),
+				Destination: netip.MustParseAddrPort("198.51.100.2:443"),
+			},
+		},
+		{
+			name:  "v1 tcp6",
+			input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n"),
+			want: &Header{
+				Version:     V1,
+				Source:      netip.MustParseAddrPort("[2001:db8::1]:12345"),
+				Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+			},
+		},
+		{
+			name:  "v1 unknown",
+			input: []byte("PROXY UNKNOWN\r\n"),
+			want:  &Header{Version: V1},
+		},
+		{
+			name:  "v1 unknown with addresses",
+			input: []byte("PROXY UNKNOWN 192.0.2.1 198.51.100.2 12345 443\r\n"),
+			want:  &Header{Version: V1},
+		},
+		{
+			name:  "v2 proxy tcp4",
+			input: headerV2(0x21, 0x11, ipv4),
+			want: &Header{
+				Version:     V2,
+				Source:      netip.MustParseAddrPort("192.0.2.1:12345"),
+				Destination: netip.MustParseAddrPort("198.51.100.2:443"),
+			},
+		},
+		{
+			name:  "v2 proxy tcp6",
+			input: headerV2(0x21, 0x21, ipv6),
+			want: &Header{
+				Version:     V2,
+				Source:      netip.MustParseAddrPort("[2001:db8::1]:12345"),
+				Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+			},
+		},
+		{
+			name:  "v2 proxy with tlv",
+			input: headerV2(0x21, 0x11, append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0x00)),
+			want: &Header{
+				Version:     V2,
+				Source:      netip.MustParseAddrPort("192.0.2.1:12345"),
+				Destination: netip.MustParseAddrPort("198.51.100.2:443"),
+			},
+		},
+		{
+			name:  "v2 local",
+			input: headerV2(0x20, 0x00, nil),
+			want:  &Header{Version: V2},
+		},
+		{
+			name:  "v2 unspecified family",
+			input: headerV2(0x21, 0x00, nil),
+			want:  &Header{Version: V2},
+		},
+		{
+			name:  "no header",
+			input: []byte("GET / HTTP/1.1\r\n"),
+			err:   ErrNoHeader,
+		},
+		{
+			name:  "empty input",
+			input: nil,
+			err:   ErrNoHeader,
+		},
+		{
+			name:  "v1 truncated signature",
+			input: []byte("PROX"),
+			err:   ErrNoHeader,
+		},
+		{
+			name:  "v1 truncated line",
+			input: []byte("PROXY TCP4 192.0.2.1"),
+			err:   io.EOF,
+		},
+		{
+			name:  "v1 without crlf",
+			input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v1 too long",
+			input: []byte("PROXY UNKNOWN " + strings.Repeat("A", maxHeaderV1) + "\r\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v1 unknown protocol",
+			input: []byte("PROXY UDP4 192.0.2.1 198.51.100.2 12345 443\r\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v1 missing port",
+			input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345\r\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v1 invalid address",
+			input: []byte("PROXY TCP4 192.0.2 198.51.100.2 12345 443\r\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v1 invalid port",
+			input: []byte("PROXY TCP4 192.0.2.1 198.51.100.2 70000 443\r\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v1 tcp4 with ipv6 addresses",
+			input: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 12345 443\r\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v1 tcp6 with ipv4 addresses",
+			input: []byte("PROXY TCP6 192.0.2.1 198.51.100.2 12345 443\r\n"),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v2 truncated signature",
+			input: signatureV2[:8],
+			err:   ErrNoHeader,
+		},
+		{
+			name:  "v2 truncated fixed part",
+			input: append(append([]byte{}, signatureV2...), 0x21, 0x11),
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "v2 truncated addresses",
+			input: headerV2(0x21, 0x11, ipv4)[:len(signatureV2)+4+6],
+			err:   io.ErrUnexpectedEOF,
+		},
+		{
+			name:  "v2 invalid version",
+			input: headerV2(0x11, 0x11, ipv4),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v2 invalid command",
+			input: headerV2(0x22, 0x11, ipv4),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v2 short tcp4 addresses",
+			input: headerV2(0x21, 0x11, ipv4[:8]),
+			err:   ErrInvalidHeader,
+		},
+		{
+			name:  "v2 short tcp6 addresses",
+			input: headerV2(0x21, 0x21, ipv6[:32]),
+			err:   ErrInvalidHeader,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header, err := Read(bufio.NewReader(bytes.NewReader(tt.input)))
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("unexpected error: %v, want %v", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("failed to read header: %v", err)
+			}
+
+			if *header != *tt.want {
+				t.Fatalf("unexpected header: %+v, want %+v", header, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadKeepsData(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "after header", input: "PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\nGET / HTTP/1.1\r\n"},
+		{name: "without header", input: "GET / HTTP/1.1\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input))
+
+			_, err := Read(reader)
+			if err != nil && !errors.Is(err, ErrNoHeader) {
+				t.Fatalf("failed to read header: %v", err)
+			}
+
+			rest, _ := io.ReadAll(reader)
+
+			if string(rest) != "GET / HTTP/1.1\r\n" {
+				t.Fatalf("unexpected data after header: %q", rest)
+			}
+		})
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	ipv4 := &Header{
+		Source:      netip.MustParseAddrPort("192.0.2.1:12345"),
+		Destination: netip.MustParseAddrPort("198.51.100.2:443"),
+	}
+
+	ipv6 := &Header{
+		Source:      netip.MustParseAddrPort("[2001:db8::1]:12345"),
+		Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+	}
+
+	tests := []struct {
+		name   string
+		header *Header
+		want   []byte
+	}{
+		{
+			name:   "v1 tcp4",
+			header: &Header{Version: V1, Source: ipv4.Source, Destination: ipv4.Destination},
+			want:   []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\n"),
+		},
+		{
+			name:   "v1 tcp6",
+			header: &Header{Version: V1, Source: ipv6.Source, Destination: ipv6.Destination},
+			want:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 443\r\n"),
+		},
+		{
+			name:   "v1 unknown",
+			header: &Header{Version: V1},
+			want:   []byte("PROXY UNKNOWN\r\n"),
+		},
+		{
+			name:   "v2 tcp4",
+			header: &Header{Version: V2, Source: ipv4.Source, Destination: ipv4.Destination},
+			want:   headerV2(0x21, 0x11, []byte{192, 0, 2, 1, 198, 51, 100, 2, 0x30, 0x39, 0x01, 0xBB}),
+		},
+		{
+			name:   "v2 local",
+			header: &Header{Version: V2},
+			want:   headerV2(0x20, 0x00, nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+
+			n, err := tt.header.WriteTo(&buf)
+			if err != nil {
+				t.Fatalf("failed to write header: %v", err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), tt.want) || n != int64(len(tt.want)) {
+				t.Fatalf("unexpected header: %q (%d bytes), want %q", buf.Bytes(), n, tt.want)
+			}
+
+			// Записанный заголовок читается обратно без изменений
+			header, err := Read(bufio.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("failed to read written header: %v", err)
+			}
+
+			if *header != *tt.header {
+				t.Fatalf("unexpected header after reading: %+v, want %+v", header, tt.header)
+			}
+		})
+	}
+
+	// Для IPv6 в версии 2 проверяется только чтение записанного заголовка
+	var buf bytes.Buffer
+
+	(&Header{Version: V2, Source: ipv6.Source, Destination: ipv6.Destination}).WriteTo(&buf)
+
+	header, err := Read(bufio.NewReader(&buf))
+	if err != nil || header.Source != ipv6.Source || header.Destination != ipv6.Destination {
+		t.Fatalf("unexpected v2 tcp6 header: %+v, %v", header, err)
+	}
+}
+
+func TestHeaderFor(t *testing.T) {
+	tcp := func(addr string) net.Addr {
+		return net.TCPAddrFromAddrPort(netip.MustParseAddrPort(addr))
+	}
+
+	tests := []struct {
+		name        string
+		source      net.Addr
+		destination net.Addr
+		want        *Header
+		line        string
+	}{
+		{
+			name:        "tcp4",
+			source:      tcp("192.0.2.1:12345"),
+			destination: tcp("198.51.100.2:443"),
+			want: &Header{
+				Version:     V1,
+				Source:      netip.MustParseAddrPort("192.0.2.1:12345"),
+				Destination: netip.MustParseAddrPort("198.51.100.2:443"),
+			},
+			line: "PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\n",
+		},
+		{
+			name:        "ipv4-mapped addresses",
+			source:      tcp("[::ffff:192.0.2.1]:12345"),
+			destination: tcp("[::ffff:198.51.100.2]:443"),
+			want: &Header{
+				Version:     V1,
+				Source:      netip.MustParseAddrPort("192.0.2.1:12345"),
+				Destination: netip.MustParseAddrPort("198.51.100.2:443"),
+			},
+			line: "PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\n",
+		},
+		{
+			name:        "mixed families",
+			source:      tcp("192.0.2.1:12345"),
+			destination: tcp("[2001:db8::2]:443"),
+			want: &Header{
+				Version:     V1,
+				Source:      netip.MustParseAddrPort("[::ffff:192.0.2.1]:12345"),
+				Destination: netip.MustParseAddrPort("[2001:db8::2]:443"),
+			},
+			line: "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 12345 443\r\n",
+		},
+		{
+			name:        "unix socket",
+			source:      &net.UnixAddr{Name: "@", Net: "unix"},
+			destination: &net.UnixAddr{Name: "/run/balancer.sock", Net: "unix"},
+			want:        &Header{Version: V1},
+			line:        "PROXY UNKNOWN\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := HeaderFor(V1, tt.source, tt.destination)
+
+			if *header != *tt.want {
+				t.Fatalf("unexpected header: %+v, want %+v", header, tt.want)
+			}
+
+			var buf bytes.Buffer
+
+			header.WriteTo(&buf)
+
+			if buf.String() != tt.line {
+				t.Fatalf("unexpected header line: %q, want %q", buf.String(), tt.line)
+			}
+		})
+	}
+}
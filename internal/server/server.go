@@ -4,25 +4,72 @@ import (
 	"context"
 	"errors"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
+const (
+	DefaultReadTimeout  = 10 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+	DefaultIdleTimeout  = time.Minute
+)
+
 type Server struct {
 	*http.Server
+
+	// Обёртка для слушателя сервера, например, для приёма заголовка PROXY
+	WrapListener func(net.Listener) net.Listener
+
+	// Сеть для слушателя (tcp или unix), по умолчанию tcp.
+	// Для unix в Addr указывается путь к сокету
+	Network string
+}
+
+// Таймауты для HTTP-сервера, нулевые значения
+// заменяются стандартными значениями
+type Timeouts struct {
+	Read       time.Duration
+	ReadHeader time.Duration
+	Write      time.Duration
+	Idle       time.Duration
 }
 
-func New(addr string, handler http.Handler) *Server {
+func New(addr string, handler http.Handler, timeouts Timeouts) *Server {
+	if timeouts.Read == 0 {
+		timeouts.Read = DefaultReadTimeout
+	}
+
+	if timeouts.Write == 0 {
+		timeouts.Write = DefaultWriteTimeout
+	}
+
+	if timeouts.Idle == 0 {
+		timeouts.Idle = DefaultIdleTimeout
+	}
+
+	// Кроме HTTP/1.1 сервер принимает HTTP/2 без шифрования (h2c),
+	// что позволяет проксировать, например, gRPC внутри кластера
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
 	return &Server{
 		Server: &http.Server{
-			Addr:         addr,
-			Handler:      handler,
-			ReadTimeout:  10 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  time.Minute,
+			Addr:              addr,
+			Handler:           handler,
+			ReadTimeout:       timeouts.Read,
+			ReadHeaderTimeout: timeouts.ReadHeader,
+			WriteTimeout:      timeouts.Write,
+			IdleTimeout:       timeouts.Idle,
+			Protocols:         protocols,
 		},
 	}
 }
@@ -49,9 +96,25 @@ func (s *Server) Listen(ctx context.Context) {
 	}()
 
 	go func() {
-		log.Printf("Started HTTP server at http://localhost%s\n", s.Addr)
+		listener, err := s.listen()
+		if err != nil {
+			log.Fatalf("Failed to start HTTP server: %v\n", err)
+		}
+
+		if s.WrapListener != nil {
+			listener = s.WrapListener(listener)
+		}
+
+		// Сертификаты задаются через TLSConfig (например, GetCertificate),
+		// поэтому пути к файлам не передаются
+		if s.TLSConfig != nil {
+			log.Printf("Started HTTPS server at %s\n", s.url("https"))
+			err = s.ServeTLS(listener, "", "")
+		} else {
+			log.Printf("Started HTTP server at %s\n", s.url("http"))
+			err = s.Serve(listener)
+		}
 
-		err := s.ListenAndServe()
 		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Failed to start HTTP server: %v\n", err)
 		}
@@ -59,3 +122,56 @@ func (s *Server) Listen(ctx context.Context) {
 
 	<-wait
 }
+
+func (s *Server) listen() (net.Listener, error) {
+	if s.Network != "unix" {
+		return net.Listen("tcp", s.Addr)
+	}
+
+	// Сокет от предыдущего запуска мешает созданию нового
+	err := os.Remove(s.Addr)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return net.Listen("unix", s.Addr)
+}
+
+func (s *Server) url(scheme string) string {
+	if s.Network == "unix" {
+		return "unix:" + s.Addr
+	}
+
+	host := s.Addr
+
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+
+	return scheme + "://" + host
+}
+
+// Создаёт сервер, который перенаправляет все запросы на HTTPS-порт балансировщика
+func NewRedirect(addr string, httpsPort uint) *Server {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.FormatUint(uint64(httpsPort), 10))
+		}
+
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     r.URL.Path,
+			RawQuery: r.URL.RawQuery,
+		}
+
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	})
+
+	return New(addr, handler, Timeouts{})
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Пара файлов с сертификатом и закрытым ключом в формате PEM
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Набор сертификатов сервера с выбором по SNI и
+// перезагрузкой при изменении файлов на диске
+type Certificates struct {
+	pairs []KeyPair
+
+	mu    sync.RWMutex
+	certs []tls.Certificate
+
+	// Время изменения файлов на момент последней загрузки
+	modified map[string]time.Time
+}
+
+func LoadCertificates(pairs []KeyPair) (*Certificates, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("empty list of certificates")
+	}
+
+	c := &Certificates{
+		pairs: pairs,
+	}
+
+	err := c.Reload()
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Загружает все сертификаты заново. При ошибке
+// продолжают использоваться ранее загруженные сертификаты
+func (c *Certificates) Reload() error {
+	certs := make([]tls.Certificate, 0, len(c.pairs))
+	modified := make(map[string]time.Time, len(c.pairs)*2)
+
+	for _, pair := range c.pairs {
+		for _, path := range []string{pair.CertFile, pair.KeyFile} {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			modified[path] = info.ModTime()
+		}
+
+		cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+		if err != nil {
+			return fmt.Errorf("load certificate %s: %w", pair.CertFile, err)
+		}
+
+		certs = append(certs, cert)
+	}
+
+	c.mu.Lock()
+	c.certs = certs
+	c.modified = modified
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Проверяет, изменились ли файлы сертификатов с момента последней загрузки
+func (c *Certificates) changed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for path, modified := range c.modified {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+
+		if !info.ModTime().Equal(modified) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Периодически проверяет файлы сертификатов и перезагружает их при изменении
+func (c *Certificates) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+
+			err := c.Reload()
+			if err != nil {
+				log.Println("Failed to reload TLS certificates:", err)
+				continue
+			}
+
+			log.Println("TLS certificates were reloaded")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Выбирает сертификат по SNI из ClientHello. Если ни один сертификат
+// не подходит, то используется первый из списка
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for i := range c.certs {
+		if hello.SupportsCertificate(&c.certs[i]) == nil {
+			return &c.certs[i], nil
+		}
+	}
+
+	return &c.certs[0], nil
+}
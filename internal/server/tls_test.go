@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Создаёт самоподписанный сертификат для имени и сохраняет его в каталог
+func writeCertificate(t *testing.T, dir, name string, serial int64) KeyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	pair := KeyPair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+
+	os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	return pair
+}
+
+func serial(t *testing.T, cert *tls.Certificate) int64 {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertificatesReload(t *testing.T) {
+	dir := t.TempDir()
+
+	first := writeCertificate(t, dir, "first.test", 1)
+	second := writeCertificate(t, dir, "second.test", 2)
+
+	certificates, err := LoadCertificates([]KeyPair{first, second})
+	if err != nil {
+		t.Fatalf("failed to load certificates: %v", err)
+	}
+
+	hello := func(name string) *tls.ClientHelloInfo {
+		return &tls.ClientHelloInfo{
+			ServerName:        name,
+			SupportedVersions: []uint16{tls.VersionTLS13},
+			SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		}
+	}
+
+	for name, want := range map[string]int64{"first.test": 1, "second.test": 2, "unknown.test": 1} {
+		cert, _ := certificates.GetCertificate(hello(name))
+
+		if got := serial(t, cert); got != want {
+			t.Fatalf("unexpected certificate for %s: %d", name, got)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go certificates.Watch(ctx, 10*time.Millisecond)
+
+	// Время изменения файла должно отличаться от предыдущего
+	time.Sleep(20 * time.Millisecond)
+	writeCertificate(t, dir, "second.test", 3)
+
+	for range 100 {
+		cert, _ := certificates.GetCertificate(hello("second.test"))
+
+		if serial(t, cert) == 3 {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatal("certificate was not reloaded")
+}
@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/imotkin/http-balancer/internal/config"
+)
+
+// Название трассировщика (instrumentation scope) для спанов балансировщика
+const Name = "github.com/imotkin/http-balancer"
+
+// Название сервиса по умолчанию
+const defaultServiceName = "http-balancer"
+
+// Формат W3C Trace Context (заголовки traceparent и tracestate)
+var Propagator = propagation.TraceContext{}
+
+// Создаёт провайдер трассировки с выбранным экспортёром. Возвращаемая функция
+// отправляет оставшиеся спаны и останавливает провайдер
+func NewProvider(ctx context.Context, cfg config.Tracing) (trace.TracerProvider, func(context.Context) error, error) {
+	if !cfg.Enabled() {
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := newExporter(ctx, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create %s exporter: %w", cfg.Exporter, err)
+	}
+
+	name := cfg.ServiceName
+
+	if name == "" {
+		name = defaultServiceName
+	}
+
+	sampler := sdktrace.AlwaysSample()
+
+	if cfg.SampleRate > 0 && cfg.SampleRate < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRate)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(name),
+		)),
+	)
+
+	return provider, provider.Shutdown, nil
+}
+
+func newExporter(ctx context.Context, cfg config.Tracing) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case config.TracingOTLPHTTP:
+		var options []otlptracehttp.Option
+
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+
+		if cfg.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+
+		return otlptracehttp.New(ctx, options...)
+	case config.TracingOTLPGRPC:
+		var options []otlptracegrpc.Option
+
+		if cfg.Endpoint != "" {
+			options = append(options, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+
+		if cfg.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+
+		return otlptracegrpc.New(ctx, options...)
+	case config.TracingStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown exporter %q", cfg.Exporter)
+	}
+}
+
+// Создаёт дочерний спан с трассировщиком родительского спана из контекста.
+// Если трассировка отключена, то спан ничего не записывает
+func Start(ctx context.Context, name string, options ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(Name).Start(ctx, name, options...)
+}
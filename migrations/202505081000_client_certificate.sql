@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE clients ADD COLUMN certificate TEXT;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+CREATE UNIQUE INDEX IF NOT EXISTS clients_certificate_idx ON clients (certificate);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS clients_certificate_idx;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients DROP COLUMN certificate;
+
+-- +goose StatementEnd
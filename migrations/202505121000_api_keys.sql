@@ -0,0 +1,93 @@
+-- +goose Up
+-- +goose StatementBegin
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL REFERENCES clients (id) ON DELETE CASCADE,
+    key_salt TEXT NOT NULL,
+    key_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+CREATE INDEX IF NOT EXISTS api_keys_client_id_idx ON api_keys (client_id);
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+INSERT INTO api_keys (id, client_id, key_salt, key_hash, created_at)
+SELECT key_id, id, key_salt, key_hash, CURRENT_TIMESTAMP
+  FROM clients;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS clients_key_id_idx;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients DROP COLUMN key_id;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients DROP COLUMN key_salt;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients DROP COLUMN key_hash;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE clients ADD COLUMN key_id TEXT;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients ADD COLUMN key_salt TEXT;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients ADD COLUMN key_hash TEXT;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+-- У клиента остаётся только самый старый ключ
+UPDATE clients
+   SET key_id = (SELECT id FROM api_keys WHERE client_id = clients.id ORDER BY created_at, id LIMIT 1),
+       key_salt = (SELECT key_salt FROM api_keys WHERE client_id = clients.id ORDER BY created_at, id LIMIT 1),
+       key_hash = (SELECT key_hash FROM api_keys WHERE client_id = clients.id ORDER BY created_at, id LIMIT 1);
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+CREATE INDEX IF NOT EXISTS clients_key_id_idx ON clients (key_id);
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS api_keys;
+
+-- +goose StatementEnd
@@ -0,0 +1,37 @@
+-- +goose Up
+-- +goose StatementBegin
+
+ALTER TABLE clients ADD COLUMN expires_at TIMESTAMP;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE api_keys ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE api_keys DROP COLUMN disabled;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients DROP COLUMN disabled;
+
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+
+ALTER TABLE clients DROP COLUMN expires_at;
+
+-- +goose StatementEnd